@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func handleMyBookingsCommand(bc BotController, update tgbotapi.Update, user User) {
+	handleMyBookings(bc, user)
+}
+
+// handleMyBookings lists user's reservations of upcoming events
+func handleMyBookings(bc BotController, user User) {
+	reservations, _ := bc.GetReservationsByUserID(user.ID)
+	lines := []string{}
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, reservation := range reservations {
+		event, err := bc.GetEvent(reservation.EventID)
+		if err != nil || event.Date.Before(time.Now()) {
+			continue
+		}
+		line := formatEventDateLocale(user.Locale, event)
+		if event.Title != "" {
+			line += " - " + event.Title
+		}
+		line += fmt.Sprintf(tr(user.Locale, "\nИмя: %s\nСтатус: %s"), reservation.EnteredName, tr(user.Locale, ReservationStatusString[reservation.Status]))
+		lines = append(lines, line)
+
+		if canCancelReservation(bc, reservation, event) {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+				tr(user.Locale, "Отменить ")+formatEventDateLocale(user.Locale, event), "cancelres:"+strconv.FormatInt(reservation.ID, 10),
+			)))
+		}
+		if canRequestRefund(bc, reservation, event) {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+				tr(user.Locale, "Вернуть оплату ")+formatEventDateLocale(user.Locale, event), "refundreq:"+strconv.FormatInt(reservation.ID, 10),
+			)))
+		}
+	}
+
+	if len(lines) == 0 {
+		sendMessage(bc, user.ID, tr(user.Locale, "У вас нет бронирований"))
+		return
+	}
+	text := tr(user.Locale, "Ваши бронирования:\n\n") + strings.Join(lines, "\n\n")
+	sendMessageKeyboard(bc, user.ID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// canCancelReservation reports whether user may cancel reservation by himself
+func canCancelReservation(bc BotController, reservation Reservation, event Event) bool {
+	return reservation.Status == Booked && time.Until(*event.Date) > bc.cfg.CancellationCutoff
+}
+
+func handleCancelReservationCallback(bc BotController, update tgbotapi.Update, user User) {
+	tokens := strings.Split(update.CallbackQuery.Data, ":")
+	reservationID, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing reservation token: %s\n", err)
+		return
+	}
+	reservation, err := bc.GetReservationByID(reservationID)
+	if err != nil || reservation.UserID != user.ID {
+		return
+	}
+	event, err := bc.GetEvent(reservation.EventID)
+	if err != nil {
+		return
+	}
+	if !canCancelReservation(bc, reservation, event) {
+		sendMessage(bc, user.ID, tr(user.Locale, "Эту бронь уже нельзя отменить, свяжитесь с поддержкой"))
+		return
+	}
+
+	if tokens[0] == "cancelres" {
+		sendMessageKeyboard(bc, user.ID, fmt.Sprintf(tr(user.Locale, "Отменить бронь на %s?"), formatEventDateLocale(user.Locale, event)),
+			tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr(user.Locale, "Да, отменить"), "cancelresconfirm:"+tokens[1]),
+				tgbotapi.NewInlineKeyboardButtonData(tr(user.Locale, "Нет"), "mybookings"),
+			)),
+		)
+		return
+	}
+
+	cancelled, err := bc.CancelReservation(reservation.ID, Booked)
+	if err != nil || !cancelled {
+		log.Printf("Error cancelling reservation %d: %v\n", reservation.ID, err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+	if user.InReservationState("enternamereservation", reservation.ID) {
+		resetState(bc, user)
+	}
+	sendMessage(bc, user.ID, tr(user.Locale, "Бронь отменена"))
+	notifyCancelled(bc, reservation, event)
+	promoteWaitlist(bc, event.ID)
+}
+
+func notifyCancelled(bc BotController, reservation Reservation, event Event) {
+	ui, _ := bc.GetUserInfo(reservation.UserID)
+	notifySupportChat(bc, fmt.Sprintf(
+		"Пользователь %s (%s) отменил бронь на %s %s, имя: %s",
+		ui.FirstName,
+		ui.Username,
+		event.Title,
+		formatEventDate(event),
+		reservation.EnteredName,
+	))
+}
+
+// expireReservations warns users about ending hold of unpaid reservations
+// and releases seats of those which were not paid in time
+func expireReservations(ctx context.Context, bc BotController) {
+	for {
+		now := time.Now()
+
+		expiring, _ := bc.GetUnpaidReservationsExpiringBefore(now.Add(bc.cfg.ReservationHoldWarn))
+		for _, reservation := range expiring {
+			if reservation.HoldWarned || reservation.ExpiresAt.Before(now) {
+				continue
+			}
+			bc.db.Model(&reservation).Update("hold_warned", true)
+			locale := bc.UserLocale(reservation.UserID)
+			event, _ := bc.GetEvent(reservation.EventID)
+			content := bc.RenderBotContent(locale, "reservation_expiring_message",
+				userTemplateVars(bc, reservation.UserID).WithReservation(bc, locale, reservation, event))
+			content.Text = fmt.Sprintf(tr(locale, "%s\nБронь действует до %s"),
+				content.Text,
+				reservation.ExpiresAt.In(dubaiLocation).Format("02.01 15:04"),
+			)
+			sendContent(bc, reservation.UserID, content, nil)
+		}
+
+		expired, _ := bc.GetUnpaidReservationsExpiringBefore(now)
+		for _, reservation := range expired {
+			ok, err := bc.ChangeReservationStatus(reservation.ID, Booked, Expired)
+			if err != nil {
+				log.Printf("Error expiring reservation %d: %s\n", reservation.ID, err)
+				continue
+			}
+			if !ok {
+				continue // paid or cancelled in the meantime
+			}
+			user := bc.GetUser(reservation.UserID)
+			if user.InReservationState("enternamereservation", reservation.ID) {
+				resetState(bc, user)
+			}
+			sendBotContent(bc, reservation.UserID, user.Locale, "reservation_expired_message", userTemplateVars(bc, reservation.UserID))
+			promoteWaitlist(bc, reservation.EventID)
+		}
+
+		if !sleepContext(ctx, 60*time.Second) {
+			return
+		}
+	}
+}
@@ -13,9 +13,11 @@ import (
 
 type BotController struct {
 	cfg     config.Config
-	bot     *tgbotapi.BotAPI
+	bot     TelegramClient
 	db      *gorm.DB
 	updates tgbotapi.UpdatesChannel
+	// stopUpdates stops receiving updates, updates channel is closed after it
+	stopUpdates func()
 }
 
 func GetBotController() BotController {
@@ -24,7 +26,13 @@ func GetBotController() BotController {
 	log.Printf("Admin password: '%v'\n", cfg.AdminPass)
 	log.Printf("Admin ID: '%v'\n", *cfg.AdminID)
 
-	bot, err := tgbotapi.NewBotAPI(cfg.BotToken)
+	var bot *tgbotapi.BotAPI
+	var err error
+	if cfg.APIEndpoint != "" {
+		bot, err = tgbotapi.NewBotAPIWithAPIEndpoint(cfg.BotToken, cfg.APIEndpoint)
+	} else {
+		bot, err = tgbotapi.NewBotAPI(cfg.BotToken)
+	}
 	if err != nil {
 		log.Panic(err)
 	}
@@ -38,12 +46,25 @@ func GetBotController() BotController {
 
 	log.Printf("Authorized on account %s", bot.Self.UserName)
 
+	client := botAPIClient{bot}
+	if cfg.WebhookURL != "" {
+		updates, stop, err := startWebhook(client, cfg)
+		if err != nil {
+			log.Panic(err)
+		}
+		return BotController{cfg: cfg, bot: client, db: db, updates: updates, stopUpdates: stop}
+	}
+
+	// webhook left from previous run would make getUpdates fail
+	if err := deleteWebhook(client); err != nil {
+		log.Printf("Error deleting webhook: %s\n", err)
+	}
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
 	updates := bot.GetUpdatesChan(u)
 
-	return BotController{cfg: cfg, bot: bot, db: db, updates: updates}
+	return BotController{cfg: cfg, bot: client, db: db, updates: updates, stopUpdates: bot.StopReceivingUpdates}
 }
 
 func (bc BotController) LogMessage(update tgbotapi.Update) error {
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram allows about 30 messages per second to different users
+const broadcastInterval = 40 * time.Millisecond
+
+var broadcastSegments = map[string]string{
+	"all":      "Все пользователи",
+	"event":    "Записавшиеся на мероприятие",
+	"paid":     "Оплатившие",
+	"never":    "Ни разу не бронировавшие",
+	"inactive": "Неактивные N дней",
+}
+
+// order of segments in keyboard
+var broadcastSegmentKeys = []string{"all", "event", "paid", "never", "inactive"}
+
+func handleBroadcastCommand(bc BotController, update tgbotapi.Update, user User) {
+	if !user.IsAdmin() {
+		return
+	}
+
+	setState(bc, user, "broadcastcompose", StatePayload{})
+}
+
+// handleBroadcastComposeMessage makes broadcast draft of admin's message
+func handleBroadcastComposeMessage(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+	msg := update.Message
+	b, err := bc.CreateBroadcast(Broadcast{
+		AuthorID:   user.ID,
+		FromChatID: msg.Chat.ID,
+		MessageID:  msg.MessageID,
+		Forward:    msg.ForwardFromChat != nil || msg.ForwardFrom != nil,
+	})
+	if err != nil {
+		log.Printf("Error creating broadcast: %s\n", err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+	resetState(bc, user)
+
+	sendMessage(bc, user.ID, "Предпросмотр:")
+	if err := deliverBroadcast(bc, b, user.ID); err != nil {
+		sendMessage(bc, user.ID, "Не удалось отправить предпросмотр: "+err.Error())
+		return
+	}
+	askBroadcastSegment(bc, user, b)
+}
+
+// handleBroadcastInactiveMessage sets number of days for inactive users segment
+func handleBroadcastInactiveMessage(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+	b, err := bc.GetBroadcast(payload.BroadcastID)
+	if err != nil {
+		resetState(bc, user)
+		return
+	}
+	days, err := strconv.ParseInt(strings.TrimSpace(update.Message.Text), 10, 64)
+	if err != nil || days <= 0 {
+		sendMessage(bc, user.ID, "Введите положительное число дней")
+		return
+	}
+	b.Segment = "inactive"
+	b.InactiveDays = days
+	bc.UpdateBroadcast(b)
+	resetState(bc, user)
+	confirmBroadcast(bc, user, b)
+}
+
+func askBroadcastSegment(bc BotController, user User, b Broadcast) {
+	id := strconv.FormatInt(b.ID, 10)
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, key := range broadcastSegmentKeys {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(broadcastSegments[key], "broadcastseg:"+id+":"+key),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Отмена", "broadcastcancel:"+id),
+	))
+	sendMessageKeyboard(bc, user.ID, "Кому отправить?", tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func confirmBroadcast(bc BotController, user User, b Broadcast) {
+	audience, err := bc.GetBroadcastAudience(b)
+	if err != nil {
+		log.Printf("Error getting broadcast audience: %s\n", err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+	segment := broadcastSegments[b.Segment]
+	if b.Segment == "event" {
+		event, _ := bc.GetEvent(b.EventID)
+		segment += " " + formatEventDate(event)
+	} else if b.Segment == "inactive" {
+		segment = fmt.Sprintf("Неактивные %d дней", b.InactiveDays)
+	}
+
+	id := strconv.FormatInt(b.ID, 10)
+	sendMessageKeyboard(bc, user.ID, fmt.Sprintf("%s: %d получателей. Отправить?", segment, len(audience)),
+		tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Отправить", "broadcastsend:"+id),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", "broadcastcancel:"+id),
+		)),
+	)
+}
+
+func handleBroadcastCallback(bc BotController, update tgbotapi.Update, user User) {
+	tokens := strings.Split(update.CallbackQuery.Data, ":")
+	if len(tokens) < 2 {
+		return
+	}
+	broadcastID, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing broadcast id: %s\n", err)
+		return
+	}
+	b, err := bc.GetBroadcast(broadcastID)
+	if err != nil || b.Status != BroadcastDraft {
+		return
+	}
+
+	switch tokens[0] {
+	case "broadcastseg":
+		if len(tokens) < 3 {
+			return
+		}
+		switch tokens[2] {
+		case "event":
+			events, _ := bc.GetAllEvents()
+			rows := [][]tgbotapi.InlineKeyboardButton{}
+			for _, event := range events {
+				rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+					formatEventDate(event), "broadcastevent:"+tokens[1]+":"+strconv.FormatInt(event.ID, 10),
+				)))
+			}
+			sendMessageKeyboard(bc, user.ID, "Выберите мероприятие", tgbotapi.NewInlineKeyboardMarkup(rows...))
+		case "inactive":
+			setState(bc, user, "broadcastinactive", StatePayload{BroadcastID: b.ID})
+			sendMessage(bc, user.ID, "Сколько дней пользователь не писал боту?")
+		default:
+			if _, ok := broadcastSegments[tokens[2]]; !ok {
+				return
+			}
+			b.Segment = tokens[2]
+			bc.UpdateBroadcast(b)
+			confirmBroadcast(bc, user, b)
+		}
+	case "broadcastevent":
+		if len(tokens) < 3 {
+			return
+		}
+		eventID, err := strconv.ParseInt(tokens[2], 10, 64)
+		if err != nil {
+			return
+		}
+		b.Segment = "event"
+		b.EventID = eventID
+		bc.UpdateBroadcast(b)
+		confirmBroadcast(bc, user, b)
+	case "broadcastsend":
+		started, err := bc.StartBroadcast(b.ID)
+		if err != nil || !started {
+			return
+		}
+		if err := bc.EnsureTask(broadcastTask(b)); err != nil {
+			log.Printf("Error scheduling broadcast %d: %s\n", b.ID, err)
+			sendMessage(bc, user.ID, "Something went wrong, try again...")
+			return
+		}
+		sendMessage(bc, user.ID, "Рассылка начата, пришлю отчёт по завершении")
+	case "broadcastcancel":
+		b.Status = BroadcastCancelled
+		bc.UpdateBroadcast(b)
+		sendMessage(bc, user.ID, "Рассылка отменена")
+	}
+}
+
+func deliverBroadcast(bc BotController, b Broadcast, chatID int64) error {
+	if b.Forward {
+		_, err := bc.bot.Request(tgbotapi.NewForward(chatID, b.FromChatID, b.MessageID))
+		return err
+	}
+	_, err := bc.bot.Request(tgbotapi.NewCopyMessage(chatID, b.FromChatID, b.MessageID))
+	return err
+}
+
+type broadcastPayload struct {
+	BroadcastID int64 `json:"broadcast_id"`
+}
+
+// broadcastTask is scheduler task which sends broadcast, it is resumed after restart
+func broadcastTask(b Broadcast) Task {
+	payload, _ := json.Marshal(broadcastPayload{BroadcastID: b.ID})
+	return Task{
+		Type:     SendBroadcast,
+		DedupKey: taskKey("broadcast:" + strconv.FormatInt(b.ID, 10)),
+		Payload:  string(payload),
+	}
+}
+
+func handleSendBroadcastTask(ctx context.Context, bc BotController, task Task) (time.Time, error) {
+	var payload broadcastPayload
+	if err := json.Unmarshal([]byte(task.Payload), &payload); err != nil {
+		log.Printf("Skipping broadcast task %d with bad payload: %s\n", task.ID, task.Payload)
+		return time.Time{}, nil
+	}
+	b, err := bc.GetBroadcast(payload.BroadcastID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if b.Status != BroadcastSending {
+		return time.Time{}, nil
+	}
+	// long broadcast is sent in parts, so task isn't taken by other worker when its lock expires
+	finished, err := runBroadcast(ctx, bc, b, time.Now().Add(taskLockDuration/2))
+	if err != nil || finished {
+		return time.Time{}, err
+	}
+	return time.Now(), nil
+}
+
+// runBroadcast delivers broadcast to users of its audience who haven't got it yet, respecting Telegram rate limits.
+// It stops at deadline or after ctx is done and returns false if some users are left.
+func runBroadcast(ctx context.Context, bc BotController, b Broadcast, deadline time.Time) (bool, error) {
+	audience, err := bc.GetBroadcastAudience(b)
+	if err != nil {
+		return false, err
+	}
+
+	ticker := time.NewTicker(broadcastInterval)
+	defer ticker.Stop()
+	for _, uid := range audience {
+		if ctx.Err() != nil || time.Now().After(deadline) {
+			return false, nil
+		}
+		claimed, err := bc.ClaimBroadcastDelivery(b.ID, uid)
+		if err != nil {
+			return false, err
+		}
+		if !claimed {
+			continue
+		}
+		<-ticker.C
+		err = deliverBroadcastWithRetry(ctx, bc, b, uid)
+		result := BroadcastDelivered
+		var tgerr *tgbotapi.Error
+		switch {
+		case err == nil:
+		case errors.As(err, &tgerr) && tgerr.Code == 403:
+			result = BroadcastBlocked
+		default:
+			log.Printf("Error delivering broadcast %d to %d: %s\n", b.ID, uid, err)
+			result = BroadcastFailed
+		}
+		if err := bc.SetBroadcastDeliveryResult(b.ID, uid, result); err != nil {
+			log.Printf("Error saving delivery of broadcast %d to %d: %s\n", b.ID, uid, err)
+		}
+	}
+
+	b, err = bc.FinishBroadcast(b)
+	if err != nil {
+		return false, err
+	}
+	sendMessage(bc, b.AuthorID, fmt.Sprintf("Рассылка завершена.\nДоставлено: %d\nЗаблокировали бота: %d\nОшибок: %d",
+		b.Delivered, b.Blocked, b.Failed))
+	return true, nil
+}
+
+func deliverBroadcastWithRetry(ctx context.Context, bc BotController, b Broadcast, chatID int64) error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		err = deliverBroadcast(bc, b, chatID)
+		var tgerr *tgbotapi.Error
+		if !errors.As(err, &tgerr) || tgerr.RetryAfter == 0 {
+			return err
+		}
+		// flood limit is hit, wait as long as Telegram asks
+		if !sleepContext(ctx, time.Duration(tgerr.RetryAfter)*time.Second) {
+			return err
+		}
+	}
+	return err
+}
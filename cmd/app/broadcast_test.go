@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBroadcastResume(t *testing.T) {
+	bc := newTestBotController(t)
+	bot, fake := newFakeBotAPI(t)
+	bc.bot = bot
+	for _, id := range []int64{1, 2, 3} {
+		bc.GetUser(id)
+	}
+	b, _ := bc.CreateBroadcast(Broadcast{AuthorID: 1, FromChatID: 1, MessageID: 10, Segment: "all", Status: BroadcastSending})
+	// user 1 got broadcast before restart
+	bc.ClaimBroadcastDelivery(b.ID, 1)
+	bc.SetBroadcastDeliveryResult(b.ID, 1, BroadcastDelivered)
+
+	// sending broadcast is picked up by scheduler on start
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	runScheduler(ctx, &wg, bc, 1)
+	wg.Wait()
+	task, claimed, err := bc.ClaimTask(time.Now(), taskLockDuration)
+	for claimed && task.Type != SendBroadcast {
+		task, claimed, err = bc.ClaimTask(time.Now(), taskLockDuration)
+	}
+	if !claimed || err != nil {
+		t.Fatalf("broadcast task is not scheduled: %v", err)
+	}
+
+	if next, err := handleSendBroadcastTask(context.Background(), bc, task); err != nil || !next.IsZero() {
+		t.Fatalf("broadcast task = %v, %v", next, err)
+	}
+	copies := fake.find("copyMessage")
+	if len(copies) != 2 || copies[0].Params["chat_id"] == "1" || copies[1].Params["chat_id"] == "1" {
+		t.Fatalf("broadcast copies: %+v", copies)
+	}
+	b, _ = bc.GetBroadcast(b.ID)
+	if b.Status != BroadcastDone || b.Delivered != 3 {
+		t.Fatalf("broadcast after sending: %+v", b)
+	}
+}
+
+func TestBroadcastDeadline(t *testing.T) {
+	bc := newTestBotController(t)
+	bot, fake := newFakeBotAPI(t)
+	bc.bot = bot
+	bc.GetUser(1)
+	b, _ := bc.CreateBroadcast(Broadcast{AuthorID: 1, FromChatID: 1, MessageID: 10, Segment: "all", Status: BroadcastSending})
+
+	if finished, err := runBroadcast(context.Background(), bc, b, time.Now().Add(-time.Second)); finished || err != nil {
+		t.Fatalf("broadcast after deadline = %v, %v", finished, err)
+	}
+	if copies := fake.find("copyMessage"); len(copies) != 0 {
+		t.Fatalf("%d copies sent after deadline", len(copies))
+	}
+	if b, _ = bc.GetBroadcast(b.ID); b.Status != BroadcastSending {
+		t.Fatalf("broadcast status is %d", b.Status)
+	}
+}
+
+func TestBroadcastStopsOnShutdown(t *testing.T) {
+	bc := newTestBotController(t)
+	bot, fake := newFakeBotAPI(t)
+	bc.bot = bot
+	bc.GetUser(1)
+	b, _ := bc.CreateBroadcast(Broadcast{AuthorID: 1, FromChatID: 1, MessageID: 10, Segment: "all", Status: BroadcastSending})
+	bc.EnsureTask(broadcastTask(b))
+	task, _, _ := bc.ClaimTask(time.Now(), taskLockDuration)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	runTask(ctx, bc, task)
+	if copies := fake.find("copyMessage"); len(copies) != 0 {
+		t.Fatalf("%d copies sent after shutdown", len(copies))
+	}
+	// task is left for next start
+	task, claimed, err := bc.ClaimTask(time.Now().Add(time.Second), taskLockDuration)
+	if !claimed || err != nil || task.Type != SendBroadcast {
+		t.Fatalf("broadcast task after shutdown: %+v, %v, %v", task, claimed, err)
+	}
+	runTask(context.Background(), bc, task)
+	if b, _ = bc.GetBroadcast(b.ID); b.Status != BroadcastDone || b.Delivered != 1 {
+		t.Fatalf("broadcast after restart: %+v", b)
+	}
+}
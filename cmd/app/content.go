@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// types of bot content, by Telegram method it's sent with
+const (
+	ContentText      = "text"
+	ContentPhoto     = "photo"
+	ContentVideo     = "video"
+	ContentAnimation = "animation"
+	ContentDocument  = "document"
+	ContentAlbum     = "album"
+)
+
+// RenderedContent is content of literal ready to be sent by sendContent
+type RenderedContent struct {
+	Type     string
+	Media    string
+	Text     string // text, or caption of media
+	Entities []tgbotapi.MessageEntity
+}
+
+// MediaItem is a file of album
+type MediaItem struct {
+	Type   string `json:"type"` // ContentPhoto, ContentVideo or ContentDocument
+	FileID string `json:"file_id"`
+}
+
+// AlbumMedia is Media of album content
+type AlbumMedia struct {
+	GroupID string      `json:"group_id"` // media_group_id of admin's messages, files of album come in separate messages
+	Items   []MediaItem `json:"items"`
+}
+
+// albumGroupMarker is how group id is written in JSON of AlbumMedia, to find album by it
+func albumGroupMarker(groupID string) string {
+	b, _ := json.Marshal(groupID)
+	return `"group_id":` + string(b)
+}
+
+// messageMedia returns type and file_id of media of message, type is empty for text
+func messageMedia(msg *tgbotapi.Message) (string, string) {
+	switch {
+	case len(msg.Photo) > 0:
+		return ContentPhoto, largestPhoto(msg.Photo)
+	case msg.Animation != nil:
+		// animations have document too, so they are checked first
+		return ContentAnimation, msg.Animation.FileID
+	case msg.Video != nil:
+		return ContentVideo, msg.Video.FileID
+	case msg.Document != nil:
+		return ContentDocument, msg.Document.FileID
+	}
+	return "", ""
+}
+
+// contentFromMessage makes content of admin's message, false if message has no supported content.
+// Message of media group becomes album of one file, others are added by handleAlbumItem.
+func contentFromMessage(msg *tgbotapi.Message) (BotContent, bool) {
+	mediaType, fileID := messageMedia(msg)
+	if mediaType == "" {
+		if msg.Text == "" {
+			return BotContent{}, false
+		}
+		b, _ := json.Marshal(msg.Entities)
+		return BotContent{Type: ContentText, Content: msg.Text, Metadata: string(b)}, true
+	}
+
+	b, _ := json.Marshal(msg.CaptionEntities)
+	c := BotContent{Type: mediaType, Media: fileID, Content: msg.Caption, Metadata: string(b)}
+	if msg.MediaGroupID != "" {
+		media, _ := json.Marshal(AlbumMedia{GroupID: msg.MediaGroupID, Items: []MediaItem{{Type: mediaType, FileID: fileID}}})
+		c.Type, c.Media = ContentAlbum, string(media)
+	}
+	return c, true
+}
+
+// isAlbumItem matches messages of media group which started album draft of their sender
+func isAlbumItem(bc BotController, msg *tgbotapi.Message) bool {
+	if msg.MediaGroupID == "" || msg.From == nil {
+		return false
+	}
+	var count int64
+	bc.db.Model(&ContentDraft{}).Where("user_id = ? AND type = ? AND media LIKE ?", msg.From.ID, ContentAlbum, "%"+albumGroupMarker(msg.MediaGroupID)+"%").Count(&count)
+	return count > 0
+}
+
+func handleAlbumItem(bc BotController, update tgbotapi.Update, user User) {
+	mediaType, fileID := messageMedia(update.Message)
+	if mediaType == "" {
+		return
+	}
+	if _, err := bc.AppendDraftAlbumItem(user.ID, update.Message.MediaGroupID, MediaItem{Type: mediaType, FileID: fileID}); err != nil {
+		log.Printf("Error adding file to album %s: %s\n", update.Message.MediaGroupID, err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// mediaMessage is admin's message with photo, album files have groupID
+func mediaMessage(userID int64, messageID int, fileID string, caption string, groupID string) tgbotapi.Update {
+	update := userMessage(userID, messageID, "")
+	update.Message.Photo = []tgbotapi.PhotoSize{{FileID: fileID, Width: 100, Height: 100, FileSize: 1000}}
+	update.Message.Caption = caption
+	update.Message.MediaGroupID = groupID
+	return update
+}
+
+func newContentTestHarness(t *testing.T) (*testHarness, int64) {
+	h := newTestHarness(t)
+	const adminID = 7
+	admin := h.bc.GetUser(adminID)
+	h.bc.db.Model(&admin).Update("role_bitmask", 0b11)
+	return h, adminID
+}
+
+func TestPhotoContent(t *testing.T) {
+	h, adminID := newContentTestHarness(t)
+	h.press(adminID, "update:start:"+defaultLocale)
+	update := mediaMessage(adminID, 10, "photo1", "Привет, {first_name}!", "")
+	update.Message.CaptionEntities = []tgbotapi.MessageEntity{{Type: "bold", Offset: 8, Length: 12}}
+	h.process(update)
+	h.press(adminID, h.button(h.last(adminID), "contentsave"))
+
+	const userID = 42
+	start := commandMessage(userID, 11, "/start")
+	start.Message.From.FirstName = "Анна"
+	h.process(start)
+	photo := h.last(userID)
+	if photo.Method != "sendPhoto" || photo.Params["photo"] != "photo1" || photo.Params["caption"] != "Привет, Анна!" {
+		t.Fatalf("start is sent as %+v", photo)
+	}
+	if !strings.Contains(photo.Params["caption_entities"], `"length":4`) {
+		t.Fatalf("caption entities are %s", photo.Params["caption_entities"])
+	}
+	h.button(photo, "mybookings")
+}
+
+func TestLongCaptionFallback(t *testing.T) {
+	h, adminID := newContentTestHarness(t)
+	h.press(adminID, "update:start:"+defaultLocale)
+	caption := strings.Repeat("я", captionLimit+1)
+	h.process(mediaMessage(adminID, 10, "photo1", caption, ""))
+	h.press(adminID, "contentsave")
+
+	const userID = 42
+	h.send(userID, "/start")
+	sent := h.fake.sentTo(userID)
+	if len(sent) != 2 || sent[0].Method != "sendPhoto" || sent[0].Params["caption"] != "" || sent[0].Params["reply_markup"] != "" {
+		t.Fatalf("media is sent as %+v", sent)
+	}
+	if sent[1].Method != "sendMessage" || sent[1].Params["text"] != caption {
+		t.Fatalf("text is sent as %+v", sent[1])
+	}
+	h.button(sent[1], "mybookings")
+}
+
+func TestAlbumContent(t *testing.T) {
+	h, adminID := newContentTestHarness(t)
+	h.press(adminID, "update:more_info_text:"+defaultLocale)
+	h.process(mediaMessage(adminID, 10, "photo1", "Как это было", "album1"))
+	h.process(mediaMessage(adminID, 11, "photo2", "", "album1"))
+	h.process(mediaMessage(adminID, 12, "photo3", "", "album1"))
+	h.press(adminID, "contentsave")
+
+	revisions, _ := h.bc.GetBotContentRevisions(defaultLocale, "more_info_text", contentHistoryLimit)
+	if len(revisions) != 1 || revisions[0].Type != ContentAlbum {
+		t.Fatalf("revisions: %+v", revisions)
+	}
+
+	const userID = 42
+	h.press(userID, "more_info")
+	album := h.last(userID)
+	if album.Method != "sendMediaGroup" {
+		t.Fatalf("album is sent as %+v", album)
+	}
+	var media []map[string]interface{}
+	json.Unmarshal([]byte(album.Params["media"]), &media)
+	if len(media) != 3 || media[0]["caption"] != "Как это было" || media[2]["media"] != "photo3" {
+		t.Fatalf("album media is %s", album.Params["media"])
+	}
+
+	// albums of users aren't taken for content
+	h.process(mediaMessage(userID, 13, "photo4", "", "album2"))
+	if c, _ := h.bc.getBotContentLocale(defaultLocale, "more_info_text"); strings.Contains(c.Media, "photo4") {
+		t.Fatal("user's photo is added to album")
+	}
+}
+
+func TestAlbumWithoutCaptionAndKeyboard(t *testing.T) {
+	h, adminID := newContentTestHarness(t)
+	h.press(adminID, "update:start:"+defaultLocale)
+	h.process(mediaMessage(adminID, 10, "photo1", "", "album1"))
+	h.process(mediaMessage(adminID, 11, "photo2", "", "album1"))
+	h.press(adminID, "contentsave")
+
+	const userID = 42
+	h.send(userID, "/start")
+	sent := h.fake.sentTo(userID)
+	if len(sent) < 2 || sent[len(sent)-2].Method != "sendMediaGroup" {
+		t.Fatalf("start is sent as %+v", sent)
+	}
+	keyboard := sent[len(sent)-1]
+	if keyboard.Params["text"] == "" || keyboard.Params["reply_markup"] == "" {
+		t.Fatalf("keyboard is sent as %+v", keyboard)
+	}
+}
+
+func TestTextOnlyLiteral(t *testing.T) {
+	h, adminID := newContentTestHarness(t)
+	h.press(adminID, "update:channel_link:"+defaultLocale)
+	h.process(mediaMessage(adminID, 10, "photo1", "https://t.me/channel", ""))
+	if _, err := h.bc.GetBotContentVerbose("channel_link"); err == nil {
+		t.Fatal("photo is set as link")
+	}
+	h.send(adminID, "https://t.me/channel")
+	h.press(adminID, "contentsave")
+	if got := h.bc.GetBotContent("channel_link"); got != "https://t.me/channel" {
+		t.Fatalf("link is %q", got)
+	}
+}
+
+func TestPreviewImageMigration(t *testing.T) {
+	h := newTestHarness(t)
+	// image of older versions is file_id in content
+	h.bc.db.Exec("INSERT INTO bot_contents (literal, locale, type, content, metadata) VALUES ('preview_image', 'ru', '', 'photo1', '')")
+	h.bc.db.Exec("INSERT INTO bot_contents (literal, locale, type, content, metadata) VALUES ('start', 'ru', '', 'Привет', '[]')")
+	db, err := OpenDB("file:" + url.PathEscape(t.Name()) + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("reopen db: %s", err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	const userID = 42
+	h.send(userID, "/start")
+	photo := h.last(userID)
+	if photo.Method != "sendPhoto" || photo.Params["photo"] != "photo1" || photo.Params["caption"] != "Привет" {
+		t.Fatalf("start is sent as %+v", photo)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// how many revisions of literal are listed in panel
+const contentHistoryLimit = 10
+
+// diffLines is line diff of old and new text, removed lines are prefixed with "- ", added with "+ "
+func diffLines(old string, new string) string {
+	a := strings.Split(old, "\n")
+	b := strings.Split(new, "\n")
+	// lcs[i][j] is length of longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	lines := []string{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+a[i])
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			j++
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatRevisionAuthor(bc BotController, authorID int64) string {
+	if authorID == 0 {
+		return "бот"
+	}
+	ui, err := bc.GetUserInfo(authorID)
+	if err != nil || ui.Username == "" {
+		return strconv.FormatInt(authorID, 10)
+	}
+	return "@" + ui.Username
+}
+
+func handleContentHistoryCallback(bc BotController, update tgbotapi.Update, user User) {
+	tokens := strings.Split(update.CallbackQuery.Data, ":")
+	if len(tokens) < 3 {
+		return
+	}
+	literal, locale := tokens[1], tokens[2]
+	revisions, err := bc.GetBotContentRevisions(locale, literal, contentHistoryLimit)
+	if err != nil {
+		log.Printf("Error getting revisions of %s: %s\n", literal, err)
+		return
+	}
+	if len(revisions) == 0 {
+		sendMessage(bc, user.ID, "История изменений пуста")
+		return
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for i, revision := range revisions {
+		label := fmt.Sprintf("#%d %s, %s", revision.ID,
+			revision.CreatedAt.In(dubaiLocation).Format("02.01 15:04"), formatRevisionAuthor(bc, revision.AuthorID))
+		if i == 0 {
+			label += " (текущая)"
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, "contentrev:"+strconv.FormatInt(revision.ID, 10)),
+		))
+	}
+	sendMessageKeyboard(bc, user.ID, "Последние изменения "+literal+" ("+localeNames[locale]+")", tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// handleContentRevision shows revision as users would see it and its difference from current content
+func handleContentRevision(bc BotController, update tgbotapi.Update, user User, id int64) {
+	revision, err := bc.GetBotContentRevision(id)
+	if err != nil {
+		sendMessage(bc, user.ID, "Версия не найдена")
+		return
+	}
+
+	var entities []tgbotapi.MessageEntity
+	json.Unmarshal([]byte(revision.Metadata), &entities)
+	preview := RenderedContent{Type: revision.Type, Media: revision.Media, Text: revision.Content, Entities: entities}
+	if err := sendContent(bc, user.ID, preview, nil); err != nil {
+		// e.g. empty text can't be sent
+		sendMessage(bc, user.ID, "Не удалось показать версию: "+err.Error())
+	}
+
+	// latest revision is current content of this language
+	var current BotContentRevision
+	if latest, _ := bc.GetBotContentRevisions(revision.Locale, revision.Literal, 1); len(latest) > 0 {
+		current = latest[0]
+	}
+	if current.Type != revision.Type || current.Media != revision.Media {
+		sendMessage(bc, user.ID, "Медиа отличается от текущего")
+	}
+	if current.Content == revision.Content {
+		sendMessage(bc, user.ID, "Текст совпадает с текущим")
+	} else {
+		sendMessage(bc, user.ID, "Отличия от текущего текста:\n"+diffLines(current.Content, revision.Content))
+	}
+
+	sendMessageKeyboard(bc, user.ID, fmt.Sprintf("Версия #%d от %s, автор %s", revision.ID,
+		revision.CreatedAt.In(dubaiLocation).Format("02.01.2006 15:04"), formatRevisionAuthor(bc, revision.AuthorID)),
+		tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Откатить к этой версии", "contentrollback:"+strconv.FormatInt(revision.ID, 10)),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Назад", "contenthistory:"+revision.Literal+":"+revision.Locale),
+			),
+		),
+	)
+}
+
+// handleContentRollback sets content of revision, rollback is saved as new revision so it can be undone too
+func handleContentRollback(bc BotController, update tgbotapi.Update, user User, id int64) {
+	revision, err := bc.GetBotContentRevision(id)
+	if err != nil {
+		sendMessage(bc, user.ID, "Версия не найдена")
+		return
+	}
+	content := BotContent{
+		Literal:  revision.Literal,
+		Locale:   revision.Locale,
+		Type:     revision.Type,
+		Media:    revision.Media,
+		Content:  revision.Content,
+		Metadata: revision.Metadata,
+	}
+	if err := bc.SaveBotContent(user.ID, content); err != nil {
+		log.Printf("Error rolling back %s to revision %d: %s\n", revision.Literal, revision.ID, err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+	// admin may still be asked for new value of this literal
+	if user.State == "contentset" && user.Payload().Literal == revision.Literal && user.Payload().Locale == revision.Locale {
+		resetState(bc, user)
+	}
+	sendMessage(bc, user.ID, fmt.Sprintf("Восстановлена версия #%d", revision.ID))
+}
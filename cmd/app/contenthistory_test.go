@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines("Привет\nМы ждём вас\nДо встречи", "Привет\nМы очень ждём вас\nДо встречи")
+	want := "  Привет\n- Мы ждём вас\n+ Мы очень ждём вас\n  До встречи"
+	if got != want {
+		t.Fatalf("diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBotContentRollback(t *testing.T) {
+	h := newTestHarness(t)
+	const adminID = 7
+	admin := h.bc.GetUser(adminID)
+	h.bc.db.Model(&admin).Update("role_bitmask", 0b11)
+
+	// content saved before revisions existed
+	h.bc.db.Create(&BotContent{Literal: "start", Locale: defaultLocale, Content: "Добро пожаловать"})
+	if err := h.bc.SetBotContentBy(adminID, defaultLocale, "start", "Опечатка", "[]"); err != nil {
+		t.Fatalf("set content: %s", err)
+	}
+	revisions, _ := h.bc.GetBotContentRevisions(defaultLocale, "start", contentHistoryLimit)
+	if len(revisions) != 2 || revisions[0].Content != "Опечатка" || revisions[0].AuthorID != adminID || revisions[1].Content != "Добро пожаловать" {
+		t.Fatalf("revisions: %+v", revisions)
+	}
+
+	h.press(adminID, "contenthistory:start:"+defaultLocale)
+	h.button(h.last(adminID), "contentrev:"+strconv.FormatInt(revisions[1].ID, 10))
+	h.press(adminID, "contentrev:"+strconv.FormatInt(revisions[1].ID, 10))
+	rollback := h.button(h.last(adminID), "contentrollback:")
+
+	// non-admins can't roll back
+	h.press(1, rollback)
+	if h.bc.GetBotContent("start") != "Опечатка" {
+		t.Fatal("content is rolled back by non-admin")
+	}
+
+	h.press(adminID, rollback)
+	if h.bc.GetBotContent("start") != "Добро пожаловать" {
+		t.Fatalf("content after rollback: %s", h.bc.GetBotContent("start"))
+	}
+	// rollback is a revision too, so it can be undone
+	revisions, _ = h.bc.GetBotContentRevisions(defaultLocale, "start", contentHistoryLimit)
+	if len(revisions) != 3 || revisions[0].Content != "Добро пожаловать" {
+		t.Fatalf("revisions after rollback: %+v", revisions)
+	}
+}
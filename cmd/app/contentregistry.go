@@ -0,0 +1,161 @@
+package main
+
+import "strings"
+
+// kinds of content literals, by what admin may set for them
+const (
+	KindMessage = "message" // text, media or album sent to users
+	KindText    = "text"    // plain text like button label or link
+	KindSetting = "setting" // value without language variants like chat id
+)
+
+// ContentLiteral describes literal editable in panel
+type ContentLiteral struct {
+	Key         string
+	Group       string // section of panel
+	Description string
+	Kind        string
+	Default     string // russian text users get while literal isn't set, translated with tr
+}
+
+// contentRegistry is every literal used by bot, in order of panel.
+// Reminder texts for offsets are added by reminderLiterals.
+var contentRegistry = []ContentLiteral{
+	{"start", "Главное меню", "Приветственный текст", KindMessage,
+		"Здравствуйте, {first_name}! Выберите мероприятие, чтобы забронировать место."},
+	{"preview_image", "Главное меню", "Медиа к приветствию, если у приветствия нет своего", KindMessage, ""},
+	{"more_info", "Главное меню", "Кнопка «Подробнее»", KindText, "Подробнее"},
+	{"more_info_text", "Главное меню", "Текст о мероприятии", KindMessage, "Подробности скоро появятся."},
+	{"leave_ticket_button", "Главное меню", "Кнопка для обращения", KindText, "Задать вопрос"},
+
+	{"reserved_message", "Бронирование", "Место забронировано, ввести имя", KindMessage,
+		"Место на {event_title} забронировано! Напишите, на чьё имя оформить бронь."},
+	{"ask_to_pay", "Бронирование", "После имени, перед оплатой", KindMessage,
+		"Спасибо, {name}! Осталось оплатить бронь: {amount}."},
+	{"soldout_message", "Бронирование", "Мест нет", KindMessage,
+		"К сожалению, все места на {event_title} заняты."},
+	{"reservation_expiring_message", "Бронирование", "Бронь скоро истечёт", KindMessage,
+		"Бронь на {event_title} ещё не оплачена и скоро истечёт."},
+	{"reservation_expired_message", "Бронирование", "Бронь истекла", KindMessage,
+		"Бронь истекла, место освобождено."},
+	{"event_cancelled_message", "Бронирование", "Мероприятие отменено", KindMessage,
+		"К сожалению, мероприятие отменено, бронь аннулирована."},
+
+	{"post_payment_message", "Оплата", "После оплаты", KindMessage,
+		"Оплата получена, ждём вас {event_date} в {event_time}!"},
+	{"manual_payment_message", "Оплата", "Реквизиты для перевода", KindMessage,
+		"Переведите оплату и отправьте фото чека в ответ на это сообщение."},
+	{"receipt_sent_message", "Оплата", "Чек отправлен", KindMessage,
+		"Чек отправлен на проверку, мы сообщим о результате."},
+	{"receipt_rejected_message", "Оплата", "Чек отклонён", KindMessage,
+		"Чек не принят, отправьте, пожалуйста, другое фото чека."},
+	{"door_payment_message", "Оплата", "Оплата на месте", KindMessage,
+		"Бронь подтверждена, оплатить можно на месте."},
+
+	{"waitlist_joined_message", "Лист ожидания", "Добавлен в лист ожидания", KindMessage,
+		"Вы в листе ожидания на {event_title}. Мы напишем, если освободится место."},
+	{"waitlist_offer_message", "Лист ожидания", "Освободилось место", KindMessage,
+		"Освободилось место на {event_title}!"},
+	{"waitlist_offer_expired_message", "Лист ожидания", "Предложение места истекло", KindMessage,
+		"Предложение места больше не действует."},
+
+	{"refund_requested_message", "Возвраты", "Запрос возврата принят", KindMessage,
+		"Запрос на возврат принят, мы свяжемся с вами."},
+	{"refund_denied_message", "Возвраты", "В возврате отказано", KindMessage,
+		"К сожалению, в возврате отказано, бронь остаётся в силе."},
+	{"refunded_message", "Возвраты", "Оплата возвращена", KindMessage,
+		"Оплата возвращена, бронь отменена."},
+
+	{"leaveticket_message", "Обращения", "Просьба оставить обращение", KindMessage,
+		"Напишите ваш вопрос одним сообщением."},
+	{"sended_notify", "Обращения", "Обращение отправлено", KindMessage,
+		"Сообщение отправлено, мы скоро ответим."},
+	{"ticket_closed_message", "Обращения", "Обращение закрыто", KindMessage,
+		"Обращение закрыто."},
+	{"ticket_reopened_message", "Обращения", "Обращение открыто снова", KindMessage,
+		"Обращение открыто снова, напишите сообщение."},
+	{"subscribe_message", "Обращения", "Просьба подписаться на канал", KindMessage,
+		"Чтобы задать вопрос, подпишитесь на наш канал."},
+
+	{"notify_pre_event", "Напоминания", "Напоминание (по умолчанию)", KindMessage,
+		"{first_name}, напоминаем о мероприятии, ждём вас!"},
+
+	{"supportchatid", "Настройки", "ID чата поддержки", KindSetting, ""},
+	{"channelid", "Настройки", "ID канала", KindSetting, ""},
+	{"channel_link", "Настройки", "Ссылка на канал", KindText, ""},
+}
+
+// prefix of reminder literals of offsets, like notify_pre_event_24h
+const reminderLiteralPrefix = "notify_pre_event_"
+
+// lookupLiteral returns registered literal by key, reminders of offsets are described as default reminder
+func lookupLiteral(key string) (ContentLiteral, bool) {
+	for _, l := range contentRegistry {
+		if l.Key == key {
+			return l, true
+		}
+	}
+	if offset, ok := strings.CutPrefix(key, reminderLiteralPrefix); ok && offset != "" {
+		l, _ := lookupLiteral("notify_pre_event")
+		l.Key, l.Description = key, "Напоминание за "+offset
+		return l, true
+	}
+	return ContentLiteral{}, false
+}
+
+// isTextOnlyLiteral is true for literals which are button labels, links or settings, media makes no sense for them
+func isTextOnlyLiteral(key string) bool {
+	l, _ := lookupLiteral(key)
+	return l.Kind == KindText || l.Kind == KindSetting
+}
+
+// isUnlocalizedLiteral is true for settings, they have no language variants
+func isUnlocalizedLiteral(key string) bool {
+	l, _ := lookupLiteral(key)
+	return l.Kind == KindSetting
+}
+
+// literalDefault is text users get in locale while literal isn't set
+func literalDefault(locale string, key string) string {
+	l, _ := lookupLiteral(key)
+	if l.Default == "" {
+		return ""
+	}
+	return tr(locale, l.Default)
+}
+
+// contentGroups are sections of panel in order of registry
+func contentGroups() []string {
+	groups := []string{}
+	for _, l := range contentRegistry {
+		if len(groups) == 0 || groups[len(groups)-1] != l.Group {
+			groups = append(groups, l.Group)
+		}
+	}
+	return groups
+}
+
+// groupLiterals returns literals of panel section, with reminders of offsets after default reminder
+func groupLiterals(bc BotController, group string) []ContentLiteral {
+	result := []ContentLiteral{}
+	for _, l := range contentRegistry {
+		if l.Group != group {
+			continue
+		}
+		result = append(result, l)
+		if l.Key == "notify_pre_event" {
+			result = append(result, reminderLiterals(bc)...)
+		}
+	}
+	return result
+}
+
+// reminderLiterals are literals of reminder texts for default and upcoming events offsets, longest offset first
+func reminderLiterals(bc BotController) []ContentLiteral {
+	result := []ContentLiteral{}
+	for _, offset := range reminderOffsetLabels(bc) {
+		l, _ := lookupLiteral(reminderLiteralPrefix + offset)
+		result = append(result, l)
+	}
+	return result
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// functions taking literal as string argument
+var contentGetters = map[string]bool{
+	"RenderBotContent":           true,
+	"sendBotContent":             true,
+	"GetBotContent":              true,
+	"GetBotContentVerbose":       true,
+	"GetBotContentLocale":        true,
+	"GetBotContentVerboseLocale": true,
+}
+
+func TestRegistryCoversLiterals(t *testing.T) {
+	files, _ := filepath.Glob("*.go")
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatalf("parse %s: %s", file, err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			name := ""
+			switch fun := call.Fun.(type) {
+			case *ast.Ident:
+				name = fun.Name
+			case *ast.SelectorExpr:
+				name = fun.Sel.Name
+			}
+			if !contentGetters[name] {
+				return true
+			}
+			for _, arg := range call.Args {
+				lit, ok := arg.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					continue
+				}
+				key, _ := strconv.Unquote(lit.Value)
+				if _, ok := lookupLiteral(key); !ok {
+					t.Errorf("%s: literal %s isn't registered", fset.Position(lit.Pos()), key)
+				}
+			}
+			return true
+		})
+	}
+}
+
+func TestRegistryDefaults(t *testing.T) {
+	keys := map[string]bool{}
+	for _, l := range contentRegistry {
+		if keys[l.Key] {
+			t.Errorf("literal %s is registered twice", l.Key)
+		}
+		keys[l.Key] = true
+		if l.Default != "" && tr("en", l.Default) == l.Default {
+			t.Errorf("default of %s has no english translation", l.Key)
+		}
+	}
+
+	h := newTestHarness(t)
+	const userID = 42
+	h.press(userID, "more_info")
+	if got := h.last(userID).Params["text"]; got != "Подробности скоро появятся." {
+		t.Fatalf("more_info_text is %q", got)
+	}
+	const englishUserID = 43
+	update := commandMessage(englishUserID, 1, "/start")
+	update.Message.From.FirstName = "Anna"
+	update.Message.From.LanguageCode = "en"
+	h.process(update)
+	start := h.last(englishUserID)
+	if got := start.Params["text"]; got != "Hello, Anna! Choose an event to book a seat." {
+		t.Fatalf("start is %q", got)
+	}
+	if !strings.Contains(start.Params["reply_markup"], "More info") {
+		t.Fatalf("buttons are %s", start.Params["reply_markup"])
+	}
+}
+
+func TestPanelSections(t *testing.T) {
+	h, adminID := newContentTestHarness(t)
+	h.press(adminID, "panel")
+	var markup tgbotapi.InlineKeyboardMarkup
+	json.Unmarshal([]byte(h.last(adminID).Params["reply_markup"]), &markup)
+	groups := contentGroups()
+	for i, group := range groups {
+		if markup.InlineKeyboard[i][0].Text != group {
+			t.Fatalf("section %d is %s, want %s", i, markup.InlineKeyboard[i][0].Text, group)
+		}
+	}
+
+	h.bc.SetBotContent("start", "Привет", "")
+	h.press(adminID, "contentgroup:0:0")
+	json.Unmarshal([]byte(h.last(adminID).Params["reply_markup"]), &markup)
+	first, second := markup.InlineKeyboard[0][0], markup.InlineKeyboard[1][0]
+	if *first.CallbackData != "update:start" || first.Text != "Приветственный текст" {
+		t.Fatalf("first literal is %+v", first)
+	}
+	if *second.CallbackData != "update:preview_image" || !strings.HasSuffix(second.Text, "(не задано)") {
+		t.Fatalf("second literal is %+v", second)
+	}
+}
+
+func TestContentPreviewBeforeSave(t *testing.T) {
+	h, adminID := newContentTestHarness(t)
+	h.press(adminID, "update:start:"+defaultLocale)
+	h.send(adminID, "Привет, {first_name}!")
+
+	sent := h.fake.sentTo(adminID)
+	if preview := sent[len(sent)-2]; preview.Params["text"] != "Привет, Анна!" {
+		t.Fatalf("preview is %+v", preview)
+	}
+	if _, err := h.bc.GetBotContentVerbose("start"); err == nil {
+		t.Fatal("content is saved before confirmation")
+	}
+
+	h.press(adminID, h.button(h.last(adminID), "contentcancel"))
+	if _, err := h.bc.GetContentDraft(adminID); err == nil {
+		t.Fatal("draft is kept after cancel")
+	}
+
+	h.press(adminID, "update:start:"+defaultLocale)
+	h.send(adminID, "Привет, {first_name}!")
+	h.press(adminID, h.button(h.last(adminID), "contentsave"))
+	if got := h.bc.GetBotContent("start"); got != "Привет, {first_name}!" {
+		t.Fatalf("saved content is %q", got)
+	}
+	if user := h.bc.GetUser(adminID); user.State != StartState {
+		t.Fatalf("state after save is %q", user.State)
+	}
+}
+
+func TestReminderLiteralsOrder(t *testing.T) {
+	bc := newTestBotController(t)
+	bc.cfg.ReminderOffsets = "1h,24h"
+	event := createTestEvent(t, bc, 10)
+	event.ReminderOffsets = "8h,30m"
+	bc.db.Save(&event)
+
+	keys := []string{}
+	for _, l := range reminderLiterals(bc) {
+		keys = append(keys, strings.TrimPrefix(l.Key, reminderLiteralPrefix))
+	}
+	if got := strings.Join(keys, ", "); got != "24h, 8h, 1h, 30m" {
+		t.Fatalf("reminder literals are %s", got)
+	}
+}
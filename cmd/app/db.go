@@ -1,19 +1,25 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type User struct {
 	gorm.Model
-	ID          int64
-	State       string
-	RoleBitmask uint
+	ID             int64
+	State          string     // name of dialog state, see dialogStates
+	StatePayload   string     // StatePayload as JSON
+	StateExpiresAt *time.Time // user is returned to start state after this time
+	RoleBitmask    uint
+	Locale         string // language of bot for user, detected from Telegram on first update
 }
 
 func (bc BotController) GetUserByID(UserID int64) (User, error) {
@@ -44,55 +50,245 @@ func (u User) IsEffectiveAdmin() bool {
 type BotContent struct {
 	gorm.Model
 	Literal  string
+	Locale   string // content of literal may have variant for each of locales
+	Type     string // one of Content* types, by Telegram method content is sent with
+	Media    string // file_id of media, or JSON of AlbumMedia for album
+	Content  string // text, or caption of media
+	Metadata string // JSON of text or caption entities
+}
+
+// BotContentRevision is a version of BotContent, one is saved on every change so it can be rolled back
+type BotContentRevision struct {
+	gorm.Model
+	ID       int64  `gorm:"primary_key"`
+	Literal  string `gorm:"index"`
+	Locale   string
+	Type     string
+	Media    string
 	Content  string
 	Metadata string
+	AuthorID int64 // admin who made change, 0 if content was set by bot
 }
 
 func GetDB() (*gorm.DB, error) {
-	db, err := gorm.Open(sqlite.Open("test.db"), &gorm.Config{})
+	return OpenDB("test.db")
+}
+
+func OpenDB(path string) (*gorm.DB, error) {
+	// updates are processed concurrently, so wait for lock instead of failing with "database is locked"
+	sep := "?" // path may have its own parameters, e.g. in-memory database of tests
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	db, err := gorm.Open(sqlite.Open(path+sep+"_busy_timeout=5000"), &gorm.Config{})
 	if err != nil {
 		return db, err
 	}
 
-	db.AutoMigrate(&User{})
-	db.AutoMigrate(&UserInfo{})
-	db.AutoMigrate(&BotContent{})
-	db.AutoMigrate(&Message{})
-	db.AutoMigrate(&Reservation{})
-	db.AutoMigrate(&Event{})
-	db.AutoMigrate(&Task{})
+	// events must be unique by date and reservations by user and event before migration adds constraints
+	if err := removeSeededEvents(db); err != nil {
+		return db, err
+	}
+	if err := removeDuplicateReservations(db); err != nil {
+		return db, err
+	}
+	err = db.AutoMigrate(
+		&User{},
+		&UserInfo{},
+		&BotContent{},
+		&BotContentRevision{},
+		&Message{},
+		&Reservation{},
+		&Event{},
+		&EventDraft{},
+		&ContentDraft{},
+		&WaitlistEntry{},
+		&PromoCode{},
+		&Broadcast{},
+		&BroadcastDelivery{},
+		&Task{},
+		&ReminderDelivery{},
+		&Ticket{},
+		&TicketMessage{},
+	)
+	if err != nil {
+		return db, err
+	}
+
+	// content saved before locales were added is in default locale
+	db.Model(&BotContent{}).Where("locale = '' OR locale IS NULL").Update("locale", defaultLocale)
+	db.Model(&BotContentRevision{}).Where("locale = '' OR locale IS NULL").Update("locale", defaultLocale)
+	// start image was kept as file_id in content before media types were added
+	for _, model := range []interface{}{&BotContent{}, &BotContentRevision{}} {
+		db.Model(model).Where("literal = ? AND (type = '' OR type IS NULL) AND content <> ''", "preview_image").
+			Updates(map[string]interface{}{"type": ContentPhoto, "media": gorm.Expr("content"), "content": ""})
+		db.Model(model).Where("type = '' OR type IS NULL").Update("type", ContentText)
+	}
+	// fixed discounts were entered in AED before promo codes had currency
+	db.Model(&PromoCode{}).Where("discount_type = ? AND (currency = '' OR currency IS NULL)", FixedDiscount).Update("currency", "AED")
 
 	return db, err
 }
 
-func (bc BotController) GetBotContentVerbose(Literal string) (string, error) {
-	var c BotContent
-	bc.db.First(&c, "Literal", Literal)
-	if c == (BotContent{}) {
-		return "[Unitialized] Init in Admin panel! Literal: " + Literal, errors.New("No content")
+// removeSeededEvents deletes copies of events which old versions created on every start,
+// reservations of copies are moved to the first event of the same date
+func removeSeededEvents(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&Event{}) {
+		return nil
+	}
+	const copies = `SELECT id FROM events e WHERE EXISTS (SELECT 1 FROM events orig WHERE orig.date = e.date AND orig.id < e.id)`
+	if db.Migrator().HasTable(&Reservation{}) {
+		err := db.Exec(`UPDATE OR IGNORE reservations SET event_id = (SELECT MIN(orig.id) FROM events orig
+				WHERE orig.date = (SELECT date FROM events e WHERE e.id = reservations.event_id))
+			WHERE event_id IN (` + copies + `)`).Error
+		if err != nil {
+			return err
+		}
+		// left are reservations of users who have reservation for first event too
+		if err := db.Exec(`DELETE FROM reservations WHERE event_id IN (` + copies + `)`).Error; err != nil {
+			return err
+		}
+	}
+	return db.Exec(`DELETE FROM events WHERE id IN (` + copies + `)`).Error
+}
+
+// removeDuplicateReservations deletes paid reservations without booking time which old versions
+// created for every message, and keeps one reservation of user per event, paid one if any
+func removeDuplicateReservations(db *gorm.DB) error {
+	if !db.Migrator().HasTable(&Reservation{}) {
+		return nil
+	}
+	if err := db.Exec(`DELETE FROM reservations WHERE time_booked IS NULL`).Error; err != nil {
+		return err
+	}
+	return db.Exec(`DELETE FROM reservations WHERE EXISTS (SELECT 1 FROM reservations r
+		WHERE r.user_id = reservations.user_id AND r.event_id = reservations.event_id AND r.id != reservations.id
+			AND ((r.status = @paid) > (reservations.status = @paid)
+				OR ((r.status = @paid) = (reservations.status = @paid) AND r.id < reservations.id)))`,
+		map[string]interface{}{"paid": Paid}).Error
+}
+
+// getBotContentLocale looks content up in locale and locales it falls back to,
+// cleared content counts as not set
+func (bc BotController) getBotContentLocale(Locale string, Literal string) (BotContent, error) {
+	for _, locale := range localeChain(Locale) {
+		var c BotContent
+		err := bc.db.Where("literal = ? AND locale = ?", Literal, locale).First(&c).Error
+		if err == nil && c.Content == "" && c.Media == "" {
+			continue
+		}
+		if err == nil {
+			return c, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return c, err
+		}
+	}
+	return BotContent{}, gorm.ErrRecordNotFound
+}
+
+// GetBotContentVerboseLocale returns text of literal, or its default with error if it isn't set
+func (bc BotController) GetBotContentVerboseLocale(Locale string, Literal string) (string, error) {
+	c, err := bc.getBotContentLocale(Locale, Literal)
+	if err != nil {
+		return literalDefault(Locale, Literal), errors.New("No content")
 	}
 	return c.Content, nil
 }
 
-func (bc BotController) GetBotContent(Literal string) string {
-	content, _ := bc.GetBotContentVerbose(Literal)
+func (bc BotController) GetBotContentLocale(Locale string, Literal string) string {
+	content, _ := bc.GetBotContentVerboseLocale(Locale, Literal)
 	return content
 }
 
-func (bc BotController) GetBotContentMetadata(Literal string) (string, error) {
-	var c BotContent
-	bc.db.First(&c, "Literal", Literal)
-	if c == (BotContent{}) {
+// GetBotContentMetadataLocale returns entities of the same variant of content as GetBotContentLocale
+func (bc BotController) GetBotContentMetadataLocale(Locale string, Literal string) (string, error) {
+	c, err := bc.getBotContentLocale(Locale, Literal)
+	if err != nil {
 		return "[]", errors.New("No metadata")
 	}
 	return c.Metadata, nil
 }
 
+func (bc BotController) GetBotContentVerbose(Literal string) (string, error) {
+	return bc.GetBotContentVerboseLocale(defaultLocale, Literal)
+}
+
+func (bc BotController) GetBotContent(Literal string) string {
+	return bc.GetBotContentLocale(defaultLocale, Literal)
+}
+
+func (bc BotController) GetBotContentMetadata(Literal string) (string, error) {
+	return bc.GetBotContentMetadataLocale(defaultLocale, Literal)
+}
+
 func (bc BotController) SetBotContent(Literal string, Content string, Metadata string) {
-	c := BotContent{Literal: Literal, Content: Content, Metadata: Metadata}
-	bc.db.FirstOrCreate(&c, "Literal", Literal)
-	bc.db.Model(&c).Update("Content", Content)
-	bc.db.Model(&c).Update("Metadata", Metadata)
+	if err := bc.SetBotContentBy(0, defaultLocale, Literal, Content, Metadata); err != nil {
+		log.Printf("Error setting content %s: %s\n", Literal, err)
+	}
+}
+
+// SetBotContentBy changes content of locale variant of literal and saves it as revision of authorID
+func (bc BotController) SetBotContentBy(authorID int64, Locale string, Literal string, Content string, Metadata string) error {
+	return bc.SaveBotContent(authorID, BotContent{Literal: Literal, Locale: Locale, Type: ContentText, Content: Content, Metadata: Metadata})
+}
+
+// SaveBotContent changes content of locale variant of literal to content of any type and saves it as revision of authorID
+func (bc BotController) SaveBotContent(authorID int64, content BotContent) error {
+	Literal, Locale := content.Literal, content.Locale
+	return bc.db.Transaction(func(tx *gorm.DB) error {
+		var c BotContent
+		err := tx.Where("literal = ? AND locale = ?", Literal, Locale).First(&c).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			// content set before revisions were introduced is kept as first revision
+			var revisions int64
+			if err := tx.Model(&BotContentRevision{}).Where("literal = ? AND locale = ?", Literal, Locale).Count(&revisions).Error; err != nil {
+				return err
+			}
+			if revisions == 0 {
+				initial := BotContentRevision{Literal: Literal, Locale: Locale, Type: c.Type, Media: c.Media, Content: c.Content, Metadata: c.Metadata}
+				initial.CreatedAt = c.UpdatedAt
+				if err := tx.Create(&initial).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		c.Literal = Literal
+		c.Locale = Locale
+		c.Type = content.Type
+		c.Media = content.Media
+		c.Content = content.Content
+		c.Metadata = content.Metadata
+		if err := tx.Save(&c).Error; err != nil {
+			return err
+		}
+		return tx.Create(&BotContentRevision{
+			Literal:  Literal,
+			Locale:   Locale,
+			Type:     c.Type,
+			Media:    c.Media,
+			Content:  c.Content,
+			Metadata: c.Metadata,
+			AuthorID: authorID,
+		}).Error
+	})
+}
+
+// GetBotContentRevisions returns up to limit latest revisions of locale variant of literal, newest first
+func (bc BotController) GetBotContentRevisions(Locale string, Literal string, limit int) ([]BotContentRevision, error) {
+	var revisions []BotContentRevision
+	err := bc.db.Where("literal = ? AND locale = ?", Literal, Locale).Order("id desc").Limit(limit).Find(&revisions).Error
+	return revisions, err
+}
+
+func (bc BotController) GetBotContentRevision(id int64) (BotContentRevision, error) {
+	var revision BotContentRevision
+	err := bc.db.First(&revision, id).Error
+	return revision, err
 }
 
 func (bc BotController) GetUser(UserID int64) User {
@@ -100,7 +296,7 @@ func (bc BotController) GetUser(UserID int64) User {
 	bc.db.First(&user, "id", UserID)
 	if user == (User{}) {
 		log.Printf("New user: [%d]", UserID)
-		user = User{ID: UserID, State: "start"}
+		user = User{ID: UserID, State: StartState}
 		bc.db.Create(&user)
 	}
 
@@ -143,11 +339,33 @@ type ReservationStatus int64
 const (
 	Booked ReservationStatus = iota
 	Paid
+	Cancelled
+	Expired
+	RefundRequested
+	Refunded
+	Refunding // refund is being made, seat is held until it's done
 )
 
 var ReservationStatusString = []string{
 	"Забронировано",
 	"Оплачено",
+	"Отменено",
+	"Истекло",
+	"Запрошен возврат",
+	"Возвращено",
+	"Возврат выполняется",
+}
+
+// seatHoldingStatuses are statuses of reservations which occupy a seat
+var seatHoldingStatuses = []ReservationStatus{Booked, Paid, RefundRequested, Refunding}
+
+func (r Reservation) HoldsSeat() bool {
+	for _, s := range seatHoldingStatuses {
+		if r.Status == s {
+			return true
+		}
+	}
+	return false
 }
 
 type Reservation struct {
@@ -158,6 +376,14 @@ type Reservation struct {
 	TimeBooked  *time.Time
 	EventID     int64 `gorm:"uniqueIndex:user_event_uniq"`
 	Status      ReservationStatus
+	ExpiresAt   *time.Time // unpaid reservation is released after this time, nil to hold forever
+	HoldWarned  bool       // user was warned that hold is about to expire
+
+	PaymentProvider  string // key of paymentProviders used to pay
+	PromoCodeID      int64  // 0 if no promo code was applied
+	TelegramChargeID string
+	ProviderChargeID string
+	ReceiptFileID    string // photo of bank transfer receipt for manual payment
 }
 
 func (bc BotController) GetAllReservations() ([]Reservation, error) {
@@ -178,15 +404,30 @@ func (bc BotController) GetReservationsByEventID(EventID int64) ([]Reservation,
 	return reservations, nil
 }
 
+// CountReservationsByEventID counts reservations which hold a seat
 func (bc BotController) CountReservationsByEventID(EventID int64) (int64, error) {
 	var count int64
-	result := bc.db.Model(&Reservation{}).Where("event_id = ?", EventID).Count(&count)
+	result := bc.db.Model(&Reservation{}).Where("event_id = ? AND status IN ?", EventID, seatHoldingStatuses).Count(&count)
 	if result.Error != nil {
 		return 0, result.Error
 	}
 	return count, nil
 }
 
+// CountTakenSeats counts reservations and seats offered to users from waitlist
+func (bc BotController) CountTakenSeats(EventID int64) (int64, error) {
+	reserved, err := bc.CountReservationsByEventID(EventID)
+	if err != nil {
+		return 0, err
+	}
+	var offered int64
+	result := bc.db.Model(&WaitlistEntry{}).Where("event_id = ? AND status = ?", EventID, Offered).Count(&offered)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return reserved + offered, nil
+}
+
 func (bc BotController) CreateReservation(userID int64, eventID int64, name string) (Reservation, error) {
 	var dubaiLocation, _ = time.LoadLocation("Asia/Dubai")
 	timenow := time.Now().In(dubaiLocation)
@@ -201,6 +442,89 @@ func (bc BotController) CreateReservation(userID int64, eventID int64, name stri
 	return reservation, result.Error
 }
 
+// seatsFreeSQL is true while reservations and pending waitlist offers
+// of other users leave at least one free seat on @event for @user
+const seatsFreeSQL = `(SELECT COUNT(*) FROM reservations WHERE event_id = @event AND status IN @holding AND deleted_at IS NULL)
+	+ (SELECT COUNT(*) FROM waitlist_entries WHERE event_id = @event AND status = @offered AND user_id != @user AND deleted_at IS NULL)
+	< (SELECT capacity FROM events WHERE id = @event AND hidden = false AND deleted_at IS NULL)`
+
+var (
+	ErrSoldOut       = errors.New("event is sold out")
+	ErrAlreadyBooked = errors.New("user already has reservation for this event")
+	ErrEventHidden   = errors.New("event is hidden")
+)
+
+// holdExpiresAt is when unpaid reservation made now is released, nil if holds don't expire
+func (bc BotController) holdExpiresAt() *time.Time {
+	if bc.cfg.ReservationHold <= 0 {
+		return nil
+	}
+	e := time.Now().Add(bc.cfg.ReservationHold)
+	return &e
+}
+
+// BookSeat atomically creates reservation only if event still has free seats.
+// Count and insert are done in a single statement, so concurrent bookings
+// can't exceed event capacity.
+func (bc BotController) BookSeat(userID int64, eventID int64, name string) (Reservation, error) {
+	expiresAt := bc.holdExpiresAt()
+
+	var existing Reservation
+	bc.db.Where("user_id = ? AND event_id = ?", userID, eventID).Limit(1).Find(&existing)
+	if existing.ID != 0 && existing.HoldsSeat() {
+		return Reservation{}, ErrAlreadyBooked
+	}
+
+	timenow := time.Now().In(dubaiLocation)
+	args := map[string]interface{}{
+		"now":       timenow,
+		"user":      userID,
+		"name":      name,
+		"event":     eventID,
+		"status":    Booked,
+		"offered":   Offered,
+		"holding":   seatHoldingStatuses,
+		"expiresat": expiresAt,
+	}
+	var result *gorm.DB
+	if existing.ID != 0 {
+		// user books event again after cancellation, expiry or refund, reuse old row without payment of old booking
+		result = bc.db.Exec(`UPDATE reservations SET status = @status, entered_name = @name, time_booked = @now, updated_at = @now,
+				expires_at = @expiresat, hold_warned = false, promo_code_id = 0, telegram_charge_id = '', provider_charge_id = '',
+				receipt_file_id = '', payment_provider = ''
+			WHERE user_id = @user AND event_id = @event AND status NOT IN @holding AND `+seatsFreeSQL, args)
+	} else {
+		result = bc.db.Exec(`INSERT INTO reservations (created_at, updated_at, user_id, entered_name, time_booked, event_id, status, expires_at, hold_warned)
+			SELECT @now, @now, @user, @name, @now, @event, @status, @expiresat, false
+			WHERE `+seatsFreeSQL, args)
+	}
+	if result.Error != nil {
+		if strings.Contains(result.Error.Error(), "UNIQUE constraint failed") {
+			return Reservation{}, ErrAlreadyBooked
+		}
+		return Reservation{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// concurrent booking of the same user has reused the row first
+		var holding int64
+		bc.db.Model(&Reservation{}).Where("user_id = ? AND event_id = ? AND status IN ?", userID, eventID, seatHoldingStatuses).Count(&holding)
+		if holding > 0 {
+			return Reservation{}, ErrAlreadyBooked
+		}
+		// hidden events have no free seats for seatsFreeSQL
+		var hidden int64
+		bc.db.Model(&Event{}).Where("id = ? AND hidden = ?", eventID, true).Count(&hidden)
+		if hidden > 0 {
+			return Reservation{}, ErrEventHidden
+		}
+		return Reservation{}, ErrSoldOut
+	}
+
+	var reservation Reservation
+	result = bc.db.Where("user_id = ? AND event_id = ?", userID, eventID).First(&reservation)
+	return reservation, result.Error
+}
+
 func (bc BotController) GetReservationByID(reservationID int64) (Reservation, error) {
 	var reservation Reservation
 	result := bc.db.First(&reservation, reservationID)
@@ -214,21 +538,234 @@ func (bc BotController) UpdateReservation(r Reservation) {
 	bc.db.Save(&r)
 }
 
+func (bc BotController) GetReservationsByUserID(UserID int64) ([]Reservation, error) {
+	var reservations []Reservation
+	result := bc.db.Where("user_id = ?", UserID).Order("created_at").Find(&reservations)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return reservations, nil
+}
+
+// ChangeReservationStatus moves reservation to status to if it is still in status from.
+// Returns false if reservation was changed in the meantime.
+func (bc BotController) ChangeReservationStatus(reservationID int64, from ReservationStatus, to ReservationStatus) (bool, error) {
+	result := bc.db.Model(&Reservation{}).
+		Where("id = ? AND status = ?", reservationID, from).
+		Update("status", to)
+	return result.RowsAffected == 1, result.Error
+}
+
+// MarkReservationPaid moves booked reservation to Paid and stores payment charge IDs.
+// Returns false if reservation is not booked anymore (e.g. hold expired).
+func (bc BotController) MarkReservationPaid(reservationID int64, telegramChargeID string, providerChargeID string) (bool, error) {
+	result := bc.db.Model(&Reservation{}).
+		Where("id = ? AND status = ?", reservationID, Booked).
+		Updates(map[string]interface{}{
+			"status":             Paid,
+			"telegram_charge_id": telegramChargeID,
+			"provider_charge_id": providerChargeID,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// RenewReservationHold gives booked reservation fresh hold window.
+// Returns false if reservation is not booked anymore.
+func (bc BotController) RenewReservationHold(reservationID int64) (bool, error) {
+	result := bc.db.Model(&Reservation{}).
+		Where("id = ? AND status = ?", reservationID, Booked).
+		Updates(map[string]interface{}{"expires_at": bc.holdExpiresAt(), "hold_warned": false})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (bc BotController) CancelReservation(reservationID int64, from ReservationStatus) (bool, error) {
+	return bc.ChangeReservationStatus(reservationID, from, Cancelled)
+}
+
+// GetUnpaidReservationsExpiringBefore returns booked reservations whose hold ends before t
+func (bc BotController) GetUnpaidReservationsExpiringBefore(t time.Time) ([]Reservation, error) {
+	var reservations []Reservation
+	result := bc.db.Where("status = ? AND expires_at IS NOT NULL AND expires_at < ?", Booked, t).Find(&reservations)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return reservations, nil
+}
+
 type Event struct {
 	gorm.Model
-	ID   int64      `gorm:"primary_key"`
-	Date *time.Time `gorm:"unique"`
+	ID       int64      `gorm:"primary_key"`
+	Date     *time.Time `gorm:"unique"`
+	Timezone string     `gorm:"default:Asia/Dubai"`
+	Capacity int64      `gorm:"default:10"`
+	Hidden   bool       // hidden events are not shown to users, but keep their reservations
+
+	Title       string
+	Description string
+	Venue       string // venue name and address
+	MapLink     string
+	Price       int64  // in minor units of Currency (fils for AED, whole stars for XTR)
+	Currency    string `gorm:"default:AED"`
+
+	PaymentProvider string `gorm:"default:telegram"` // key of paymentProviders
+	ReminderOffsets string // comma separated durations before event, e.g. "24h,1h"; empty for default from config
+}
+
+// LocalDate returns event date in event's own timezone
+func (e Event) LocalDate() time.Time {
+	if e.Date == nil {
+		return time.Time{}
+	}
+	loc, err := time.LoadLocation(e.Timezone)
+	if err != nil {
+		return *e.Date
+	}
+	return e.Date.In(loc)
 }
 
 func (bc BotController) GetAllEvents() ([]Event, error) {
 	var events []Event
-	result := bc.db.Find(&events)
+	result := bc.db.Order("date").Find(&events)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return events, nil
 }
 
+func (bc BotController) CreateEvent(event Event) (Event, error) {
+	result := bc.db.Create(&event)
+	return event, result.Error
+}
+
+func (bc BotController) UpdateEvent(event Event) error {
+	result := bc.db.Save(&event)
+	return result.Error
+}
+
+// DeleteEvent removes event permanently, so its date can be reused
+func (bc BotController) DeleteEvent(EventID int64) error {
+	result := bc.db.Unscoped().Delete(&Event{}, EventID)
+	return result.Error
+}
+
+// EventDraft holds values entered by admin during event create/edit dialog
+type EventDraft struct {
+	gorm.Model
+	UserID   int64 `gorm:"uniqueIndex"`
+	EventID  int64 // 0 for new event
+	Mode     string
+	Date     string
+	Time     string
+	Timezone string
+	Capacity int64
+
+	Title       string
+	Description string
+	Venue       string
+	MapLink     string
+	Price       int64
+	Currency    string
+}
+
+func (bc BotController) GetEventDraft(UserID int64) (EventDraft, error) {
+	var draft EventDraft
+	result := bc.db.First(&draft, "user_id = ?", UserID)
+	if result.Error != nil {
+		return EventDraft{}, result.Error
+	}
+	return draft, nil
+}
+
+func (bc BotController) SaveEventDraft(draft EventDraft) error {
+	bc.DeleteEventDraft(draft.UserID)
+	draft.ID = 0
+	result := bc.db.Create(&draft)
+	return result.Error
+}
+
+func (bc BotController) UpdateEventDraft(draft EventDraft) error {
+	result := bc.db.Save(&draft)
+	return result.Error
+}
+
+func (bc BotController) DeleteEventDraft(UserID int64) error {
+	result := bc.db.Unscoped().Where("user_id = ?", UserID).Delete(&EventDraft{})
+	return result.Error
+}
+
+// ContentDraft holds content sent by admin in panel until it's saved
+type ContentDraft struct {
+	gorm.Model
+	UserID   int64 `gorm:"uniqueIndex"`
+	Literal  string
+	Locale   string
+	Type     string
+	Media    string
+	Content  string
+	Metadata string
+}
+
+// BotContent is content the draft would be saved as
+func (d ContentDraft) BotContent() BotContent {
+	return BotContent{Literal: d.Literal, Locale: d.Locale, Type: d.Type, Media: d.Media, Content: d.Content, Metadata: d.Metadata}
+}
+
+func (bc BotController) GetContentDraft(UserID int64) (ContentDraft, error) {
+	var draft ContentDraft
+	result := bc.db.First(&draft, "user_id = ?", UserID)
+	if result.Error != nil {
+		return ContentDraft{}, result.Error
+	}
+	return draft, nil
+}
+
+func (bc BotController) SaveContentDraft(draft ContentDraft) error {
+	bc.DeleteContentDraft(draft.UserID)
+	draft.ID = 0
+	result := bc.db.Create(&draft)
+	return result.Error
+}
+
+func (bc BotController) DeleteContentDraft(UserID int64) error {
+	result := bc.db.Unscoped().Where("user_id = ?", UserID).Delete(&ContentDraft{})
+	return result.Error
+}
+
+// AppendDraftAlbumItem adds item to album draft of admin which was started by message of media group.
+// Returns false if admin has no such album.
+func (bc BotController) AppendDraftAlbumItem(UserID int64, groupID string, item MediaItem) (bool, error) {
+	appended := false
+	err := bc.db.Transaction(func(tx *gorm.DB) error {
+		var draft ContentDraft
+		err := tx.Where("user_id = ? AND type = ? AND media LIKE ?", UserID, ContentAlbum, "%"+albumGroupMarker(groupID)+"%").First(&draft).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var album AlbumMedia
+		if err := json.Unmarshal([]byte(draft.Media), &album); err != nil {
+			return err
+		}
+		album.Items = append(album.Items, item)
+		media, _ := json.Marshal(album)
+		if err := tx.Model(&draft).Update("media", string(media)).Error; err != nil {
+			return err
+		}
+		appended = true
+		return nil
+	})
+	return appended, err
+}
+
+// IsBotContentSet is true if literal is set in any locale
+func (bc BotController) IsBotContentSet(Literal string) bool {
+	var count int64
+	bc.db.Model(&BotContent{}).Where("literal = ? AND (content <> '' OR media <> '')", Literal).Count(&count)
+	return count > 0
+}
+
 func (bc BotController) GetEvent(EventID int64) (Event, error) {
 	var event Event
 	result := bc.db.First(&event, EventID)
@@ -238,34 +775,576 @@ func (bc BotController) GetEvent(EventID int64) (Event, error) {
 	return event, nil
 }
 
+type WaitlistStatus int64
+
+const (
+	Waiting WaitlistStatus = iota
+	Offered
+	Accepted
+	Declined
+	OfferExpired
+)
+
+type WaitlistEntry struct {
+	gorm.Model
+	ID             int64 `gorm:"primary_key"`
+	UserID         int64 `gorm:"uniqueIndex:waitlist_user_event_uniq"`
+	EventID        int64 `gorm:"uniqueIndex:waitlist_user_event_uniq"`
+	Position       int64
+	Status         WaitlistStatus
+	OfferExpiresAt *time.Time
+}
+
+// JoinWaitlist puts user at the end of event's waitlist.
+// If user already waits or has an offer, existing entry is returned.
+func (bc BotController) JoinWaitlist(userID int64, eventID int64) (WaitlistEntry, error) {
+	var entry WaitlistEntry
+	bc.db.Where("user_id = ? AND event_id = ?", userID, eventID).First(&entry)
+	if entry.ID != 0 && (entry.Status == Waiting || entry.Status == Offered) {
+		return entry, nil
+	}
+
+	// position is taken in the same statement, so concurrent joins get different positions
+	args := map[string]interface{}{
+		"now":     time.Now(),
+		"user":    userID,
+		"event":   eventID,
+		"id":      entry.ID,
+		"waiting": Waiting,
+		"active":  []WaitlistStatus{Waiting, Offered},
+	}
+	const nextPosition = `(SELECT COALESCE(MAX(position), 0) + 1 FROM waitlist_entries WHERE event_id = @event)`
+	var result *gorm.DB
+	if entry.ID != 0 {
+		// user joins again after declining or missing offer
+		result = bc.db.Exec(`UPDATE waitlist_entries SET position = `+nextPosition+`, status = @waiting, offer_expires_at = NULL, updated_at = @now
+			WHERE id = @id AND status NOT IN @active`, args)
+	} else {
+		result = bc.db.Exec(`INSERT INTO waitlist_entries (created_at, updated_at, user_id, event_id, position, status)
+			SELECT @now, @now, @user, @event, `+nextPosition+`, @waiting`, args)
+	}
+	// concurrent join of the same user is fine, its entry is returned
+	if result.Error != nil && !strings.Contains(result.Error.Error(), "UNIQUE constraint failed") {
+		return WaitlistEntry{}, result.Error
+	}
+
+	entry = WaitlistEntry{}
+	err := bc.db.Where("user_id = ? AND event_id = ?", userID, eventID).First(&entry).Error
+	return entry, err
+}
+
+func (bc BotController) GetWaitlistEntry(entryID int64) (WaitlistEntry, error) {
+	var entry WaitlistEntry
+	result := bc.db.First(&entry, entryID)
+	if result.Error != nil {
+		return WaitlistEntry{}, result.Error
+	}
+	return entry, nil
+}
+
+// SetWaitlistEntryStatus changes status of entry only if it's still from,
+// returns false if entry was changed concurrently
+func (bc BotController) SetWaitlistEntryStatus(entryID int64, from WaitlistStatus, to WaitlistStatus) (bool, error) {
+	result := bc.db.Model(&WaitlistEntry{}).Where("id = ? AND status = ?", entryID, from).Update("status", to)
+	return result.RowsAffected > 0, result.Error
+}
+
+var ErrOfferExpired = errors.New("waitlist offer is expired")
+
+// AcceptWaitlistOffer books seat offered to user of entry and marks offer accepted in one transaction,
+// so offer can't expire and be given to next user in between
+func (bc BotController) AcceptWaitlistOffer(entry WaitlistEntry, name string) (Reservation, error) {
+	var reservation Reservation
+	err := bc.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&WaitlistEntry{}).Where("id = ? AND status = ? AND offer_expires_at > ?", entry.ID, Offered, time.Now()).
+			Update("status", Accepted)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOfferExpired
+		}
+		txbc := bc
+		txbc.db = tx
+		var err error
+		reservation, err = txbc.BookSeat(entry.UserID, entry.EventID, name)
+		return err
+	})
+	return reservation, err
+}
+
+// CloseWaitlist declines all waiting users and offers of event, e.g. when it's cancelled
+func (bc BotController) CloseWaitlist(eventID int64) error {
+	result := bc.db.Model(&WaitlistEntry{}).Where("event_id = ? AND status IN ?", eventID, []WaitlistStatus{Waiting, Offered}).
+		Update("status", Declined)
+	return result.Error
+}
+
+// GetWaitlist returns entries which are still waiting or have an offer, in queue order
+func (bc BotController) GetWaitlist(eventID int64) ([]WaitlistEntry, error) {
+	var entries []WaitlistEntry
+	result := bc.db.Where("event_id = ? AND status IN ?", eventID, []WaitlistStatus{Waiting, Offered}).
+		Order("position").Find(&entries)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return entries, nil
+}
+
+// WaitlistPosition returns 1-based place of entry among waiting users
+func (bc BotController) WaitlistPosition(entry WaitlistEntry) int64 {
+	var count int64
+	bc.db.Model(&WaitlistEntry{}).
+		Where("event_id = ? AND status = ? AND position <= ?", entry.EventID, Waiting, entry.Position).
+		Count(&count)
+	return count
+}
+
+// OfferNextInWaitlist atomically gives a free seat to the first waiting user.
+// Returns false if there are no free seats or nobody is waiting.
+func (bc BotController) OfferNextInWaitlist(eventID int64, expiresAt time.Time) (WaitlistEntry, bool, error) {
+	for {
+		var next WaitlistEntry
+		result := bc.db.Where("event_id = ? AND status = ?", eventID, Waiting).Order("position").Limit(1).Find(&next)
+		if result.Error != nil {
+			return WaitlistEntry{}, false, result.Error
+		}
+		if next.ID == 0 {
+			return WaitlistEntry{}, false, nil
+		}
+
+		result = bc.db.Exec(`UPDATE waitlist_entries SET status = @offered, offer_expires_at = @expires, updated_at = @now
+			WHERE id = @id AND status = @waiting AND `+seatsFreeSQL,
+			map[string]interface{}{
+				"offered": Offered,
+				"holding": seatHoldingStatuses,
+				"waiting": Waiting,
+				"expires": expiresAt,
+				"now":     time.Now(),
+				"id":      next.ID,
+				"event":   eventID,
+				"user":    next.UserID,
+			},
+		)
+		if result.Error != nil {
+			return WaitlistEntry{}, false, result.Error
+		}
+		if result.RowsAffected == 1 {
+			next.Status = Offered
+			next.OfferExpiresAt = &expiresAt
+			return next, true, nil
+		}
+
+		// entry was changed concurrently, check if there are still free seats
+		var still WaitlistEntry
+		bc.db.First(&still, next.ID)
+		if still.Status == Waiting {
+			return WaitlistEntry{}, false, nil
+		}
+	}
+}
+
+func (bc BotController) GetExpiredWaitlistOffers(now time.Time) ([]WaitlistEntry, error) {
+	var entries []WaitlistEntry
+	result := bc.db.Where("status = ? AND offer_expires_at < ?", Offered, now).Find(&entries)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return entries, nil
+}
+
+// MoveWaitlistEntry swaps entry with its waiting neighbour; up moves it closer to the head of queue
+func (bc BotController) MoveWaitlistEntry(entryID int64, up bool) error {
+	entry, err := bc.GetWaitlistEntry(entryID)
+	if err != nil {
+		return err
+	}
+
+	var neighbour WaitlistEntry
+	query := bc.db.Where("event_id = ? AND status = ?", entry.EventID, Waiting)
+	if up {
+		query = query.Where("position < ?", entry.Position).Order("position DESC")
+	} else {
+		query = query.Where("position > ?", entry.Position).Order("position")
+	}
+	result := query.Limit(1).Find(&neighbour)
+	if result.Error != nil {
+		return result.Error
+	}
+	if neighbour.ID == 0 {
+		return nil
+	}
+
+	return bc.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&entry).Update("position", neighbour.Position).Error; err != nil {
+			return err
+		}
+		return tx.Model(&neighbour).Update("position", entry.Position).Error
+	})
+}
+
+type DiscountType int64
+
+const (
+	PercentDiscount DiscountType = iota
+	FixedDiscount
+)
+
+type PromoCode struct {
+	gorm.Model
+	ID           int64  `gorm:"primary_key"`
+	Code         string `gorm:"uniqueIndex"` // stored in upper case
+	DiscountType DiscountType
+	Discount     int64  // percent or minor units of Currency
+	Currency     string // currency of fixed discount, it isn't applied to events in other currencies
+	UsageLimit   int64  // 0 for unlimited
+	PerUserLimit int64  // 0 for unlimited
+	ValidFrom    *time.Time
+	ValidUntil   *time.Time
+	EventID      int64 // 0 if code is valid for any event
+	Disabled     bool
+	Draft        bool // code is being created by admin
+}
+
+// AppliesTo is false for fixed discount in currency other than event's one
+func (p PromoCode) AppliesTo(event Event) bool {
+	return p.DiscountType == PercentDiscount || p.Currency == event.Currency
+}
+
+// Apply returns price of event after discount
+func (p PromoCode) Apply(event Event) int64 {
+	price := event.Price
+	if !p.AppliesTo(event) {
+		return price
+	}
+	var discount int64
+	if p.DiscountType == PercentDiscount {
+		discount = price * p.Discount / 100
+	} else {
+		discount = p.Discount
+	}
+	if discount > price {
+		return 0
+	}
+	return price - discount
+}
+
+func (bc BotController) GetPromoCode(promoID int64) (PromoCode, error) {
+	var promo PromoCode
+	result := bc.db.First(&promo, promoID)
+	if result.Error != nil {
+		return PromoCode{}, result.Error
+	}
+	return promo, nil
+}
+
+func (bc BotController) GetPromoCodeByCode(code string) (PromoCode, error) {
+	var promo PromoCode
+	result := bc.db.Where("code = ? AND draft = ?", strings.ToUpper(code), false).First(&promo)
+	if result.Error != nil {
+		return PromoCode{}, result.Error
+	}
+	return promo, nil
+}
+
+func (bc BotController) GetAllPromoCodes() ([]PromoCode, error) {
+	var promos []PromoCode
+	result := bc.db.Where("draft = ?", false).Order("created_at").Find(&promos)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return promos, nil
+}
+
+func (bc BotController) HasActivePromoCodes() bool {
+	var count int64
+	bc.db.Model(&PromoCode{}).Where("draft = ? AND disabled = ?", false, false).Count(&count)
+	return count > 0
+}
+
+func (bc BotController) CreatePromoCode(promo PromoCode) (PromoCode, error) {
+	result := bc.db.Create(&promo)
+	return promo, result.Error
+}
+
+func (bc BotController) UpdatePromoCode(promo PromoCode) error {
+	result := bc.db.Save(&promo)
+	return result.Error
+}
+
+func (bc BotController) DeletePromoCode(promoID int64) error {
+	result := bc.db.Unscoped().Delete(&PromoCode{}, promoID)
+	return result.Error
+}
+
+var ErrPromoLimitReached = errors.New("promo code usage limit is reached")
+
+// ApplyPromoCode atomically attaches promo to reservation if its usage limits allow it
+func (bc BotController) ApplyPromoCode(reservation Reservation, promo PromoCode) error {
+	result := bc.db.Exec(`UPDATE reservations SET promo_code_id = @promo, updated_at = @now
+		WHERE id = @id AND status = @booked
+			AND (@limit = 0 OR (SELECT COUNT(*) FROM reservations WHERE promo_code_id = @promo AND status IN @holding AND deleted_at IS NULL) < @limit)
+			AND (@userlimit = 0 OR (SELECT COUNT(*) FROM reservations WHERE promo_code_id = @promo AND user_id = @user AND status IN @holding AND deleted_at IS NULL) < @userlimit)`,
+		map[string]interface{}{
+			"promo":     promo.ID,
+			"now":       time.Now(),
+			"id":        reservation.ID,
+			"booked":    Booked,
+			"limit":     promo.UsageLimit,
+			"userlimit": promo.PerUserLimit,
+			"user":      reservation.UserID,
+			"holding":   seatHoldingStatuses,
+		},
+	)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrPromoLimitReached
+	}
+	return nil
+}
+
+// ReservationPrice returns amount user has to pay for reservation, with promo code applied
+func (bc BotController) ReservationPrice(reservation Reservation, event Event) int64 {
+	if reservation.PromoCodeID == 0 {
+		return event.Price
+	}
+	promo, err := bc.GetPromoCode(reservation.PromoCodeID)
+	if err != nil {
+		return event.Price
+	}
+	return promo.Apply(event)
+}
+
+type BroadcastStatus int64
+
+const (
+	BroadcastDraft BroadcastStatus = iota
+	BroadcastSending
+	BroadcastDone
+	BroadcastCancelled
+)
+
+// Broadcast is a message composed by admin to be copied to a segment of users
+type Broadcast struct {
+	gorm.Model
+	ID           int64 `gorm:"primary_key"`
+	AuthorID     int64
+	FromChatID   int64 // chat and message which will be copied to users
+	MessageID    int
+	Forward      bool   // forward instead of copy, to keep "forwarded from" header
+	Segment      string // key of broadcastSegments
+	EventID      int64  // for "event" segment
+	InactiveDays int64  // for "inactive" segment
+	Status       BroadcastStatus
+	Delivered    int64
+	Blocked      int64
+	Failed       int64
+}
+
+func (bc BotController) CreateBroadcast(b Broadcast) (Broadcast, error) {
+	result := bc.db.Create(&b)
+	return b, result.Error
+}
+
+func (bc BotController) GetBroadcast(broadcastID int64) (Broadcast, error) {
+	var b Broadcast
+	result := bc.db.First(&b, broadcastID)
+	if result.Error != nil {
+		return Broadcast{}, result.Error
+	}
+	return b, nil
+}
+
+func (bc BotController) UpdateBroadcast(b Broadcast) error {
+	result := bc.db.Save(&b)
+	return result.Error
+}
+
+// StartBroadcast moves draft to sending, so it is never delivered twice
+func (bc BotController) StartBroadcast(broadcastID int64) (bool, error) {
+	result := bc.db.Model(&Broadcast{}).
+		Where("id = ? AND status = ?", broadcastID, BroadcastDraft).
+		Update("status", BroadcastSending)
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetSendingBroadcasts returns broadcasts which were started and not finished
+func (bc BotController) GetSendingBroadcasts() ([]Broadcast, error) {
+	var broadcasts []Broadcast
+	result := bc.db.Where("status = ?", BroadcastSending).Find(&broadcasts)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return broadcasts, nil
+}
+
+type BroadcastResult int64
+
+const (
+	BroadcastPending BroadcastResult = iota // claimed, bot may have stopped before sending
+	BroadcastDelivered
+	BroadcastBlocked
+	BroadcastFailed
+)
+
+// BroadcastDelivery records broadcast sent to user, so it is sent once even if sending is resumed after restart
+type BroadcastDelivery struct {
+	gorm.Model
+	ID          int64 `gorm:"primary_key"`
+	BroadcastID int64 `gorm:"uniqueIndex:broadcast_delivery_uniq"`
+	UserID      int64 `gorm:"uniqueIndex:broadcast_delivery_uniq"`
+	Result      BroadcastResult
+}
+
+// ClaimBroadcastDelivery records broadcast as being sent to user, returns false if it was already sent
+func (bc BotController) ClaimBroadcastDelivery(broadcastID int64, userID int64) (bool, error) {
+	delivery := BroadcastDelivery{BroadcastID: broadcastID, UserID: userID}
+	result := bc.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&delivery)
+	return result.RowsAffected == 1, result.Error
+}
+
+func (bc BotController) SetBroadcastDeliveryResult(broadcastID int64, userID int64, r BroadcastResult) error {
+	result := bc.db.Model(&BroadcastDelivery{}).Where("broadcast_id = ? AND user_id = ?", broadcastID, userID).Update("result", r)
+	return result.Error
+}
+
+// FinishBroadcast marks broadcast done and saves its stats counted from deliveries
+func (bc BotController) FinishBroadcast(b Broadcast) (Broadcast, error) {
+	counts := map[BroadcastResult]*int64{BroadcastDelivered: &b.Delivered, BroadcastBlocked: &b.Blocked, BroadcastFailed: &b.Failed}
+	for r, count := range counts {
+		if err := bc.db.Model(&BroadcastDelivery{}).Where("broadcast_id = ? AND result = ?", b.ID, r).Count(count).Error; err != nil {
+			return b, err
+		}
+	}
+	b.Status = BroadcastDone
+	return b, bc.UpdateBroadcast(b)
+}
+
+// GetBroadcastAudience returns IDs of users in broadcast's segment
+func (bc BotController) GetBroadcastAudience(b Broadcast) ([]int64, error) {
+	var ids []int64
+	query := bc.db.Model(&User{})
+	switch b.Segment {
+	case "all":
+	case "event":
+		query = query.Where("id IN (?)", bc.db.Model(&Reservation{}).Select("user_id").
+			Where("event_id = ? AND status IN ?", b.EventID, seatHoldingStatuses))
+	case "paid":
+		query = query.Where("id IN (?)", bc.db.Model(&Reservation{}).Select("user_id").
+			Where("status = ?", Paid))
+	case "never":
+		query = query.Where("id NOT IN (?)", bc.db.Model(&Reservation{}).Select("user_id"))
+	case "inactive":
+		since := time.Now().AddDate(0, 0, -int(b.InactiveDays))
+		query = query.Where("id NOT IN (?)", bc.db.Model(&Message{}).Select("user_id").
+			Where("datetime > ?", since))
+	default:
+		return nil, errors.New("unknown broadcast segment: " + b.Segment)
+	}
+	result := query.Pluck("id", &ids)
+	return ids, result.Error
+}
+
 type TaskType int64
 
 const (
 	SyncSheet TaskType = iota
 	NotifyAboutEvent
+	SendBroadcast
 )
 
+type TaskStatus int64
+
+const (
+	TaskPending TaskStatus = iota
+	TaskRunning
+	TaskDone
+	TaskFailed // gave up after MaxAttempts
+)
+
+// unlimitedAttempts is MaxAttempts of recurring tasks, they are retried until they succeed.
+// Zero can't be used, it is replaced by default.
+const unlimitedAttempts = -1
+
 type Task struct {
 	gorm.Model
-	ID      int64 `gorm:"primary_key"`
-	Type    TaskType
-	EventID int64
+	ID          int64 `gorm:"primary_key"`
+	Type        TaskType
+	EventID     int64
+	DedupKey    *string    `gorm:"uniqueIndex"` // at most one task with same key, nil for anonymous tasks
+	Payload     string     // task specific data, JSON
+	RunAt       time.Time  `gorm:"index"`
+	Status      TaskStatus `gorm:"index"`
+	Attempts    int
+	MaxAttempts int `gorm:"default:5"`
+	LastError   string
+	LockedUntil *time.Time // task claimed by worker, may be reclaimed after this time if worker died
 }
 
 func (bc BotController) CreateSimpleTask(taskType TaskType) error {
 	task := Task{
-		Type: taskType,
+		Type:  taskType,
+		RunAt: time.Now(),
 	}
 	return bc.CreateTask(task)
 }
 
 func (bc BotController) CreateTask(task Task) error {
+	if task.RunAt.IsZero() {
+		task.RunAt = time.Now()
+	}
 	result := bc.db.Create(&task)
 	return result.Error
 }
 
+// EnsureTask creates task unless task with same DedupKey already exists, whatever its status
+func (bc BotController) EnsureTask(task Task) error {
+	if task.RunAt.IsZero() {
+		task.RunAt = time.Now()
+	}
+	result := bc.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&task)
+	return result.Error
+}
+
+// EnsureRecurringTask creates task like EnsureTask with unlimited attempts.
+// Existing task is given unlimited attempts too and is revived if it was given up before.
+func (bc BotController) EnsureRecurringTask(task Task) error {
+	task.MaxAttempts = unlimitedAttempts
+	if err := bc.EnsureTask(task); err != nil {
+		return err
+	}
+	result := bc.db.Model(&Task{}).Where("dedup_key = ?", *task.DedupKey).Updates(map[string]interface{}{
+		"max_attempts": unlimitedAttempts,
+		"status":       gorm.Expr("CASE WHEN status = ? THEN ? ELSE status END", TaskFailed, TaskPending),
+	})
+	return result.Error
+}
+
+// ScheduleTask creates task or reschedules existing one with same DedupKey to run again at task.RunAt
+func (bc BotController) ScheduleTask(task Task) error {
+	if task.RunAt.IsZero() {
+		task.RunAt = time.Now()
+	}
+	result := bc.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "dedup_key"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"run_at":       task.RunAt,
+			"payload":      task.Payload,
+			"event_id":     task.EventID,
+			"status":       TaskPending,
+			"attempts":     0,
+			"last_error":   "",
+			"locked_until": nil,
+			"deleted_at":   nil,
+		}),
+	}).Create(&task)
+	return result.Error
+}
+
 func (bc BotController) DeleteTask(taskID int64) error {
-	result := bc.db.Delete(&Task{}, taskID)
+	result := bc.db.Unscoped().Delete(&Task{}, taskID)
 	return result.Error
 }
 
@@ -277,3 +1356,178 @@ func (bc BotController) GetAllTasks() ([]Task, error) {
 	}
 	return tasks, nil
 }
+
+// ClaimTask takes one due task for worker. Running tasks whose lock has expired
+// (e.g. bot was restarted mid-run) are due again. Returns false if nothing is due.
+func (bc BotController) ClaimTask(now time.Time, lock time.Duration) (Task, bool, error) {
+	args := map[string]interface{}{
+		"now":     now,
+		"pending": TaskPending,
+		"running": TaskRunning,
+		"until":   now.Add(lock),
+	}
+	due := "deleted_at IS NULL AND ((status = @pending AND run_at <= @now) OR (status = @running AND locked_until <= @now))"
+	for {
+		var task Task
+		result := bc.db.Where(due, args).Order("run_at").Limit(1).Find(&task)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return Task{}, false, result.Error
+		}
+
+		// another worker may claim same task in between, then try next one
+		args["id"] = task.ID
+		result = bc.db.Exec(`UPDATE tasks SET status = @running, locked_until = @until, attempts = attempts + 1
+			WHERE id = @id AND `+due, args)
+		if result.Error != nil {
+			return Task{}, false, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return bc.GetTask(task.ID)
+		}
+	}
+}
+
+func (bc BotController) GetTask(taskID int64) (Task, bool, error) {
+	var task Task
+	result := bc.db.First(&task, taskID)
+	if result.Error != nil {
+		return Task{}, false, result.Error
+	}
+	return task, true, nil
+}
+
+// FinishTask marks claimed task done, or schedules it again at next if next is not zero
+func (bc BotController) FinishTask(task Task, next time.Time) error {
+	updates := map[string]interface{}{"status": TaskDone, "last_error": "", "locked_until": nil}
+	if !next.IsZero() {
+		updates = map[string]interface{}{"status": TaskPending, "run_at": next, "attempts": 0, "last_error": "", "locked_until": nil}
+	}
+	result := bc.db.Model(&Task{}).Where("id = ? AND status = ?", task.ID, TaskRunning).Updates(updates)
+	return result.Error
+}
+
+// FailTask records error of claimed task and retries it at retryAt, unless attempts are exhausted
+func (bc BotController) FailTask(task Task, taskErr error, retryAt time.Time) error {
+	updates := map[string]interface{}{"status": TaskPending, "run_at": retryAt, "last_error": taskErr.Error(), "locked_until": nil}
+	if task.MaxAttempts > 0 && task.Attempts >= task.MaxAttempts {
+		updates["status"] = TaskFailed
+	}
+	result := bc.db.Model(&Task{}).Where("id = ? AND status = ?", task.ID, TaskRunning).Updates(updates)
+	return result.Error
+}
+
+// ReminderDelivery records reminder sent for reservation, so each reminder is sent once
+type ReminderDelivery struct {
+	gorm.Model
+	ID            int64  `gorm:"primary_key"`
+	EventID       int64  `gorm:"index"`
+	ReservationID int64  `gorm:"uniqueIndex:reminder_delivery_uniq"`
+	Offset        string `gorm:"column:reminder_offset;uniqueIndex:reminder_delivery_uniq"`
+}
+
+// ClaimReminderDelivery records reminder as sent, returns false if it was already sent
+func (bc BotController) ClaimReminderDelivery(reservation Reservation, offset string) (bool, error) {
+	delivery := ReminderDelivery{EventID: reservation.EventID, ReservationID: reservation.ID, Offset: offset}
+	result := bc.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&delivery)
+	return result.RowsAffected == 1, result.Error
+}
+
+// ReleaseReminderDelivery forgets reminder, so it is sent again, e.g. after failed delivery
+func (bc BotController) ReleaseReminderDelivery(reservation Reservation, offset string) error {
+	result := bc.db.Unscoped().Where("reservation_id = ? AND reminder_offset = ?", reservation.ID, offset).Delete(&ReminderDelivery{})
+	return result.Error
+}
+
+// ResetReminderDeliveries forgets all reminders of event, e.g. when event is rescheduled
+func (bc BotController) ResetReminderDeliveries(eventID int64) error {
+	result := bc.db.Unscoped().Where("event_id = ?", eventID).Delete(&ReminderDelivery{})
+	return result.Error
+}
+
+// DeleteStaleEventTasks removes pending tasks of event of given type which keys are not in keep
+func (bc BotController) DeleteStaleEventTasks(eventID int64, taskType TaskType, keep []string) error {
+	query := bc.db.Unscoped().Where("event_id = ? AND type = ? AND status = ?", eventID, taskType, TaskPending)
+	if len(keep) > 0 {
+		query = query.Where("dedup_key NOT IN ?", keep)
+	}
+	result := query.Delete(&Task{})
+	return result.Error
+}
+
+type TicketStatus int64
+
+const (
+	TicketOpen TicketStatus = iota
+	TicketClosed
+)
+
+// Ticket is user's request to support, discussed in support chat
+type Ticket struct {
+	gorm.Model
+	ID               int64 `gorm:"primary_key"`
+	UserID           int64 `gorm:"index"`
+	Status           TicketStatus
+	SupportMessageID int `gorm:"index"` // header message of ticket in support chat
+}
+
+// TicketMessage is one message of ticket thread, from user or support staff
+type TicketMessage struct {
+	gorm.Model
+	ID               int64 `gorm:"primary_key"`
+	TicketID         int64 `gorm:"index"`
+	FromSupport      bool
+	AuthorID         int64
+	Text             string // text or caption
+	MediaType        string // photo, voice, etc.; empty for text
+	FileID           string
+	SupportMessageID int `gorm:"index"` // copy of message in support chat, or staff message itself
+}
+
+func (bc BotController) CreateTicket(ticket Ticket) (Ticket, error) {
+	result := bc.db.Create(&ticket)
+	return ticket, result.Error
+}
+
+func (bc BotController) GetTicket(ticketID int64) (Ticket, error) {
+	var ticket Ticket
+	result := bc.db.First(&ticket, ticketID)
+	return ticket, result.Error
+}
+
+func (bc BotController) UpdateTicket(ticket Ticket) error {
+	result := bc.db.Save(&ticket)
+	return result.Error
+}
+
+// ChangeTicketStatus changes status of ticket, returns false if ticket already had it
+func (bc BotController) ChangeTicketStatus(ticketID int64, status TicketStatus) (bool, error) {
+	result := bc.db.Model(&Ticket{}).Where("id = ? AND status <> ?", ticketID, status).Update("status", status)
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetOpenTicket returns latest open ticket of user
+func (bc BotController) GetOpenTicket(userID int64) (Ticket, error) {
+	var ticket Ticket
+	result := bc.db.Where("user_id = ? AND status = ?", userID, TicketOpen).Order("id DESC").First(&ticket)
+	return ticket, result.Error
+}
+
+// GetTicketBySupportMessage finds ticket which header or message in support chat has given id
+func (bc BotController) GetTicketBySupportMessage(messageID int) (Ticket, error) {
+	var ticket Ticket
+	result := bc.db.Where("support_message_id = ?", messageID).
+		Or("id IN (?)", bc.db.Model(&TicketMessage{}).Select("ticket_id").Where("support_message_id = ?", messageID)).
+		First(&ticket)
+	return ticket, result.Error
+}
+
+func (bc BotController) AddTicketMessage(message TicketMessage) (TicketMessage, error) {
+	result := bc.db.Create(&message)
+	return message, result.Error
+}
+
+func (bc BotController) GetTicketMessages(ticketID int64) ([]TicketMessage, error) {
+	var messages []TicketMessage
+	result := bc.db.Where("ticket_id = ?", ticketID).Order("id").Find(&messages)
+	return messages, result.Error
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestBotController(t *testing.T) BotController {
+	t.Helper()
+	db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("open db: %s", err)
+	}
+	return BotController{db: db}
+}
+
+func createTestEvent(t *testing.T, bc BotController, capacity int64) Event {
+	t.Helper()
+	date := time.Now().Add(24 * time.Hour)
+	event, err := bc.CreateEvent(Event{Date: &date, Capacity: capacity})
+	if err != nil {
+		t.Fatalf("create event: %s", err)
+	}
+	return event
+}
+
+func TestBookSeatConcurrent(t *testing.T) {
+	bc := newTestBotController(t)
+	const capacity = 5
+	const users = 50
+	event := createTestEvent(t, bc, capacity)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	booked := 0
+	start := make(chan struct{})
+	for i := 1; i <= users; i++ {
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			<-start
+			_, err := bc.BookSeat(userID, event.ID, "name")
+			if errors.Is(err, ErrSoldOut) {
+				return
+			}
+			if err != nil {
+				t.Errorf("book seat for user %d: %s", userID, err)
+				return
+			}
+			mu.Lock()
+			booked++
+			mu.Unlock()
+		}(int64(i))
+	}
+	close(start)
+	wg.Wait()
+
+	if booked != capacity {
+		t.Errorf("booked %d seats, want %d", booked, capacity)
+	}
+	taken, _ := bc.CountReservationsByEventID(event.ID)
+	if taken != capacity {
+		t.Errorf("event has %d reservations, want %d", taken, capacity)
+	}
+}
+
+func TestBookSeatConcurrentSameUser(t *testing.T) {
+	bc := newTestBotController(t)
+	event := createTestEvent(t, bc, 5)
+
+	bookTwice := func() int {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		booked := 0
+		start := make(chan struct{})
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				_, err := bc.BookSeat(1, event.ID, "name")
+				if errors.Is(err, ErrAlreadyBooked) {
+					return
+				}
+				if err != nil {
+					t.Errorf("book seat: %s", err)
+					return
+				}
+				mu.Lock()
+				booked++
+				mu.Unlock()
+			}()
+		}
+		close(start)
+		wg.Wait()
+		return booked
+	}
+
+	if booked := bookTwice(); booked != 1 {
+		t.Fatalf("user booked %d times", booked)
+	}
+	// cancelled row is reused by next booking
+	reservations, _ := bc.GetReservationsByEventID(event.ID)
+	bc.ChangeReservationStatus(reservations[0].ID, Booked, Cancelled)
+	if booked := bookTwice(); booked != 1 {
+		t.Fatalf("user booked %d times after cancel", booked)
+	}
+	if taken, _ := bc.CountReservationsByEventID(event.ID); taken != 1 {
+		t.Fatalf("event has %d reservations", taken)
+	}
+}
+
+func TestBookSeatTwice(t *testing.T) {
+	bc := newTestBotController(t)
+	event := createTestEvent(t, bc, 5)
+
+	if _, err := bc.BookSeat(1, event.ID, "name"); err != nil {
+		t.Fatalf("first booking: %s", err)
+	}
+	if _, err := bc.BookSeat(1, event.ID, "name"); !errors.Is(err, ErrAlreadyBooked) {
+		t.Errorf("second booking error = %v, want ErrAlreadyBooked", err)
+	}
+}
+
+func TestWaitlistOfferHoldsSeat(t *testing.T) {
+	bc := newTestBotController(t)
+	event := createTestEvent(t, bc, 1)
+	expires := time.Now().Add(time.Hour)
+
+	first, err := bc.BookSeat(1, event.ID, "name")
+	if err != nil {
+		t.Fatalf("book seat: %s", err)
+	}
+	if _, err := bc.JoinWaitlist(2, event.ID); err != nil {
+		t.Fatalf("join waitlist: %s", err)
+	}
+	if _, offered, _ := bc.OfferNextInWaitlist(event.ID, expires); offered {
+		t.Fatalf("offered seat of sold out event")
+	}
+
+	bc.db.Unscoped().Delete(&first)
+	entry, offered, err := bc.OfferNextInWaitlist(event.ID, expires)
+	if err != nil || !offered || entry.UserID != 2 {
+		t.Fatalf("offer = %v, %v, %v; want offer to user 2", entry, offered, err)
+	}
+	if _, err := bc.BookSeat(3, event.ID, "name"); !errors.Is(err, ErrSoldOut) {
+		t.Errorf("booking of offered seat by other user: %v, want ErrSoldOut", err)
+	}
+	if _, err := bc.BookSeat(2, event.ID, "name"); err != nil {
+		t.Errorf("booking of offered seat: %s", err)
+	}
+}
+
+func TestJoinWaitlistConcurrent(t *testing.T) {
+	bc := newTestBotController(t)
+	event := createTestEvent(t, bc, 1)
+	const users = 20
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 1; i <= users; i++ {
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			<-start
+			if _, err := bc.JoinWaitlist(userID, event.ID); err != nil {
+				t.Errorf("join waitlist for user %d: %s", userID, err)
+			}
+		}(int64(i))
+	}
+	close(start)
+	wg.Wait()
+
+	positions := map[int64]bool{}
+	for i := int64(1); i <= users; i++ {
+		entry, _ := bc.JoinWaitlist(i, event.ID)
+		if positions[entry.Position] {
+			t.Fatalf("position %d is given twice", entry.Position)
+		}
+		positions[entry.Position] = true
+	}
+
+	// user who declined joins at the end again
+	entry, _ := bc.JoinWaitlist(1, event.ID)
+	bc.SetWaitlistEntryStatus(entry.ID, Waiting, Declined)
+	if entry, _ = bc.JoinWaitlist(1, event.ID); entry.Position != users+1 || entry.Status != Waiting {
+		t.Fatalf("entry after joining again: %+v", entry)
+	}
+}
+
+func TestWaitlistOfferAnsweredAtDeadline(t *testing.T) {
+	bc := newTestBotController(t)
+	event := createTestEvent(t, bc, 2)
+	bc.JoinWaitlist(1, event.ID)
+	bc.JoinWaitlist(2, event.ID)
+	first, _, _ := bc.OfferNextInWaitlist(event.ID, time.Now().Add(time.Hour))
+	second, _, _ := bc.OfferNextInWaitlist(event.ID, time.Now().Add(time.Hour))
+
+	// offer expired right before it's accepted
+	if ok, _ := bc.SetWaitlistEntryStatus(first.ID, Offered, OfferExpired); !ok {
+		t.Fatal("offer isn't expired")
+	}
+	if _, err := bc.AcceptWaitlistOffer(first, "name"); !errors.Is(err, ErrOfferExpired) {
+		t.Fatalf("accept of expired offer: %v, want ErrOfferExpired", err)
+	}
+	if count, _ := bc.CountReservationsByEventID(event.ID); count != 0 {
+		t.Fatalf("%d seats booked by expired offer", count)
+	}
+
+	// offer accepted right before it expires
+	if _, err := bc.AcceptWaitlistOffer(second, "name"); err != nil {
+		t.Fatalf("accept offer: %s", err)
+	}
+	if ok, _ := bc.SetWaitlistEntryStatus(second.ID, Offered, OfferExpired); ok {
+		t.Fatal("accepted offer is expired")
+	}
+	if entry, _ := bc.GetWaitlistEntry(second.ID); entry.Status != Accepted {
+		t.Fatalf("status of accepted offer is %d", entry.Status)
+	}
+}
+
+func TestCancelledReservationFreesSeat(t *testing.T) {
+	bc := newTestBotController(t)
+	event := createTestEvent(t, bc, 1)
+
+	reservation, err := bc.BookSeat(1, event.ID, "name")
+	if err != nil {
+		t.Fatalf("book seat: %s", err)
+	}
+	if cancelled, err := bc.CancelReservation(reservation.ID, Booked); !cancelled || err != nil {
+		t.Fatalf("cancel reservation = %v, %v", cancelled, err)
+	}
+	if cancelled, _ := bc.CancelReservation(reservation.ID, Booked); cancelled {
+		t.Errorf("reservation cancelled twice")
+	}
+	if _, err := bc.BookSeat(2, event.ID, "name"); err != nil {
+		t.Fatalf("book freed seat: %s", err)
+	}
+	if _, err := bc.BookSeat(1, event.ID, "name"); !errors.Is(err, ErrSoldOut) {
+		t.Errorf("rebooking of sold out event: %v, want ErrSoldOut", err)
+	}
+}
+
+func TestExpiredReservationFreesSeat(t *testing.T) {
+	bc := newTestBotController(t)
+	bc.cfg.ReservationHold = time.Hour
+	event := createTestEvent(t, bc, 1)
+
+	reservation, err := bc.BookSeat(1, event.ID, "name")
+	if err != nil {
+		t.Fatalf("book seat: %s", err)
+	}
+	if reservation.ExpiresAt == nil {
+		t.Fatalf("reservation has no hold expiry")
+	}
+	if expiring, _ := bc.GetUnpaidReservationsExpiringBefore(time.Now()); len(expiring) != 0 {
+		t.Errorf("got %d expired reservations, want 0", len(expiring))
+	}
+	expiring, _ := bc.GetUnpaidReservationsExpiringBefore(time.Now().Add(2 * time.Hour))
+	if len(expiring) != 1 {
+		t.Fatalf("got %d expiring reservations, want 1", len(expiring))
+	}
+
+	if ok, err := bc.ChangeReservationStatus(reservation.ID, Booked, Expired); !ok || err != nil {
+		t.Fatalf("expire reservation = %v, %v", ok, err)
+	}
+	if _, err := bc.BookSeat(2, event.ID, "name"); err != nil {
+		t.Errorf("book released seat: %s", err)
+	}
+}
+
+func TestPromoCodeLimits(t *testing.T) {
+	bc := newTestBotController(t)
+	event := createTestEvent(t, bc, 5)
+	promo, err := bc.CreatePromoCode(PromoCode{Code: "SPRING", DiscountType: PercentDiscount, Discount: 20, UsageLimit: 1})
+	if err != nil {
+		t.Fatalf("create promo code: %s", err)
+	}
+	if price := promo.Apply(Event{Price: 15000, Currency: "AED"}); price != 12000 {
+		t.Errorf("price with 20%% discount = %d, want 12000", price)
+	}
+
+	first, _ := bc.BookSeat(1, event.ID, "name")
+	second, _ := bc.BookSeat(2, event.ID, "name")
+	if err := bc.ApplyPromoCode(first, promo); err != nil {
+		t.Fatalf("apply promo code: %s", err)
+	}
+	if err := bc.ApplyPromoCode(second, promo); !errors.Is(err, ErrPromoLimitReached) {
+		t.Errorf("apply promo code over limit: %v, want ErrPromoLimitReached", err)
+	}
+
+	event.Price = 15000
+	first, _ = bc.GetReservationByID(first.ID)
+	if price := bc.ReservationPrice(first, event); price != 12000 {
+		t.Errorf("reservation price = %d, want 12000", price)
+	}
+}
+
+func TestPromoCodeCurrency(t *testing.T) {
+	bc := newTestBotController(t)
+	var aed, stars PromoCode
+	if err := applyPromoDraftStep(bc, &aed, "discount", "50"); err != nil || aed.Discount != 5000 || aed.Currency != "AED" {
+		t.Fatalf("AED discount: %+v, %v", aed, err)
+	}
+	if err := applyPromoDraftStep(bc, &stars, "discount", "50 XTR"); err != nil || stars.Discount != 50 || stars.Currency != "XTR" {
+		t.Fatalf("stars discount: %+v, %v", stars, err)
+	}
+
+	aedEvent := Event{Price: 15000, Currency: "AED"}
+	starsEvent := Event{Price: 500, Currency: "XTR"}
+	if price := aed.Apply(aedEvent); price != 10000 {
+		t.Errorf("AED event price with AED discount = %d, want 10000", price)
+	}
+	if price := stars.Apply(starsEvent); price != 450 {
+		t.Errorf("stars event price with stars discount = %d, want 450", price)
+	}
+	if price := aed.Apply(starsEvent); price != 500 {
+		t.Errorf("stars event price with AED discount = %d, want 500", price)
+	}
+	if price := stars.Apply(aedEvent); price != 15000 {
+		t.Errorf("AED event price with stars discount = %d, want 15000", price)
+	}
+
+	date := time.Now().Add(72 * time.Hour)
+	event, _ := bc.CreateEvent(Event{Date: &date, Capacity: 5, Price: 500, Currency: "XTR"})
+	aed.Code = "AED50"
+	bc.CreatePromoCode(aed)
+	reservation, _ := bc.BookSeat(1, event.ID, "name")
+	if _, err := validatePromoCode(bc, "AED50", reservation); err == nil {
+		t.Error("AED discount is accepted for stars event")
+	}
+}
+
+func TestRemoveSeededEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	legacy, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open legacy db: %s", err)
+	}
+	// old versions created the same events on every start
+	legacy.Exec("CREATE TABLE events (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime, deleted_at datetime, date datetime)")
+	legacy.Exec(`CREATE TABLE reservations (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime, deleted_at datetime,
+		user_id integer, entered_name text, time_booked datetime, event_id integer, status integer)`)
+	for i := 0; i < 3; i++ {
+		legacy.Exec("INSERT INTO events (date) VALUES ('2025-03-28 18:00:00+04:00'), ('2025-04-01 18:00:00+04:00')")
+	}
+	legacy.Exec("INSERT INTO reservations (user_id, entered_name, time_booked, event_id, status) VALUES (1, 'Анна', '2025-03-20 10:00:00+04:00', 4, 0)")
+	if sqlDB, err := legacy.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("open db: %s", err)
+	}
+	bc := BotController{db: db}
+	events, _ := bc.GetAllEvents()
+	if len(events) != 2 || events[0].ID != 1 || events[1].ID != 2 {
+		t.Fatalf("events after migration: %+v", events)
+	}
+	reservations, _ := bc.GetAllReservations()
+	if len(reservations) != 1 || reservations[0].EventID != 2 {
+		t.Fatalf("reservations after migration: %+v", reservations)
+	}
+}
+
+func TestRemoveDuplicateReservations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	legacy, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open legacy db: %s", err)
+	}
+	legacy.Exec(`CREATE TABLE reservations (id integer PRIMARY KEY AUTOINCREMENT, created_at datetime, updated_at datetime, deleted_at datetime,
+		user_id integer, entered_name text, time_booked datetime, event_id integer, status integer)`)
+	// old versions created paid reservation for event 1 on every message
+	legacy.Exec("INSERT INTO reservations (user_id, event_id, status) VALUES (1, 1, 1), (1, 1, 1), (2, 1, 1)")
+	legacy.Exec(`INSERT INTO reservations (user_id, entered_name, time_booked, event_id, status) VALUES
+		(3, 'Анна', '2025-03-20 10:00:00+04:00', 1, 0), (3, 'Анна', '2025-03-20 10:01:00+04:00', 1, 1)`)
+	if sqlDB, err := legacy.DB(); err == nil {
+		sqlDB.Close()
+	}
+
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("open db: %s", err)
+	}
+	bc := BotController{db: db}
+	reservations, _ := bc.GetAllReservations()
+	if len(reservations) != 1 || reservations[0].UserID != 3 || reservations[0].Status != Paid {
+		t.Fatalf("reservations after migration: %+v", reservations)
+	}
+	if !db.Migrator().HasIndex(&Reservation{}, "user_event_uniq") {
+		t.Fatal("reservations have no unique index")
+	}
+}
+
+func TestRebookClearsPayment(t *testing.T) {
+	bc := newTestBotController(t)
+	date := time.Now().Add(72 * time.Hour)
+	event, _ := bc.CreateEvent(Event{Date: &date, Capacity: 5, Price: 15000, Currency: "AED"})
+	promo, _ := bc.CreatePromoCode(PromoCode{Code: "HALF", DiscountType: PercentDiscount, Discount: 50})
+
+	reservation, _ := bc.BookSeat(1, event.ID, "name")
+	if err := bc.ApplyPromoCode(reservation, promo); err != nil {
+		t.Fatalf("apply promo code: %s", err)
+	}
+	bc.db.Model(&reservation).Updates(map[string]interface{}{"payment_provider": "manual", "receipt_file_id": "receipt1"})
+	bc.MarkReservationPaid(reservation.ID, "tg-charge", "provider-charge")
+	bc.ChangeReservationStatus(reservation.ID, Paid, Refunded)
+
+	reservation, err := bc.BookSeat(1, event.ID, "name")
+	if err != nil {
+		t.Fatalf("book again: %s", err)
+	}
+	if reservation.PromoCodeID != 0 || reservation.TelegramChargeID != "" || reservation.ProviderChargeID != "" ||
+		reservation.ReceiptFileID != "" || reservation.PaymentProvider != "" {
+		t.Fatalf("reservation booked again keeps old payment: %+v", reservation)
+	}
+	if price := bc.ReservationPrice(reservation, event); price != 15000 {
+		t.Fatalf("price of reservation booked again = %d, want 15000", price)
+	}
+}
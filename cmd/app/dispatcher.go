@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Dispatcher processes updates by fixed number of workers. Updates of the same user (or chat,
+// if update has no sender) go to the same worker, so they are processed one by one in order
+// they came, while different users are processed in parallel.
+type Dispatcher struct {
+	queues []chan tgbotapi.Update
+	handle func(update tgbotapi.Update)
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher starts workers, each has queue of queueSize updates
+func NewDispatcher(workers int, queueSize int, handle func(update tgbotapi.Update)) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	d := &Dispatcher{handle: handle}
+	for i := 0; i < workers; i++ {
+		queue := make(chan tgbotapi.Update, queueSize)
+		d.queues = append(d.queues, queue)
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			for update := range queue {
+				d.handle(update)
+			}
+		}()
+	}
+	return d
+}
+
+// updateKey is id of user or chat whose updates must be processed in order
+func updateKey(update tgbotapi.Update) int64 {
+	if from := update.SentFrom(); from != nil {
+		return from.ID
+	}
+	if chat := update.FromChat(); chat != nil {
+		return chat.ID
+	}
+	return 0
+}
+
+// Dispatch queues update, it blocks while queue of update's worker is full
+func (d *Dispatcher) Dispatch(update tgbotapi.Update) {
+	key := updateKey(update)
+	if key < 0 {
+		key = -key // group chats have negative ids
+	}
+	d.queues[key%int64(len(d.queues))] <- update
+}
+
+// Stop waits until queued updates are processed and stops workers, Dispatch must not be called after it
+func (d *Dispatcher) Stop() {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.wg.Wait()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestDispatcherOrderPerUser(t *testing.T) {
+	var mu sync.Mutex
+	got := map[int64][]int{}
+	d := NewDispatcher(4, 10, func(update tgbotapi.Update) {
+		// later updates would overtake earlier ones if they were processed in parallel
+		time.Sleep(time.Duration(10-update.UpdateID%10) * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		uid := update.Message.From.ID
+		got[uid] = append(got[uid], update.UpdateID)
+	})
+
+	for i := 0; i < 30; i++ {
+		update := userMessage(int64(i%3+1), i, "msg")
+		update.UpdateID = i
+		d.Dispatch(update)
+	}
+	d.Stop() // all queued updates are processed before Stop returns
+
+	for uid := int64(1); uid <= 3; uid++ {
+		if len(got[uid]) != 10 {
+			t.Fatalf("user %d: %d updates processed, want 10", uid, len(got[uid]))
+		}
+		for i := 1; i < len(got[uid]); i++ {
+			if got[uid][i] < got[uid][i-1] {
+				t.Fatalf("updates of user %d processed out of order: %v", uid, got[uid])
+			}
+		}
+	}
+}
+
+func TestDispatcherUsersInParallel(t *testing.T) {
+	release := make(chan struct{})
+	done := make(chan int64, 2)
+	d := NewDispatcher(2, 1, func(update tgbotapi.Update) {
+		if update.Message.From.ID == 1 {
+			<-release
+		}
+		done <- update.Message.From.ID
+	})
+	defer d.Stop()
+
+	d.Dispatch(userMessage(1, 1, "slow"))
+	d.Dispatch(userMessage(2, 2, "fast"))
+	select {
+	case uid := <-done:
+		if uid != 2 {
+			t.Fatalf("user %d processed first", uid)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("user is blocked by other user's update")
+	}
+	close(release)
+	<-done
+}
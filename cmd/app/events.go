@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+func formatEventDate(event Event) string {
+	return formatEventDateLocale(defaultLocale, event)
+}
+
+func formatEventDateLocale(locale string, event Event) string {
+	date := event.LocalDate()
+	wday := tr(locale, WeekLabels[int(date.Weekday())])
+	return date.Format("02.01.2006") + " (" + wday + ") " + date.Format("15:04")
+}
+
+// currencyHasMinorUnits is false for Telegram Stars, which are charged in whole stars
+func currencyHasMinorUnits(currency string) bool {
+	return currency != "XTR"
+}
+
+func formatPrice(price int64, currency string) string {
+	if price == 0 {
+		return "Бесплатно"
+	}
+	if !currencyHasMinorUnits(currency) {
+		return fmt.Sprintf("%d %s", price, currency)
+	}
+	if price%100 == 0 {
+		return fmt.Sprintf("%d %s", price/100, currency)
+	}
+	return fmt.Sprintf("%d.%02d %s", price/100, price%100, currency)
+}
+
+// eventDetails renders event info shown to users
+func eventDetails(locale string, event Event) string {
+	lines := []string{}
+	if event.Title != "" {
+		lines = append(lines, event.Title)
+	}
+	lines = append(lines, tr(locale, "Дата: ")+formatEventDateLocale(locale, event))
+	if event.Venue != "" {
+		lines = append(lines, tr(locale, "Место: ")+event.Venue)
+	}
+	if event.MapLink != "" {
+		lines = append(lines, tr(locale, "Карта: ")+event.MapLink)
+	}
+	lines = append(lines, tr(locale, "Стоимость: ")+tr(locale, formatPrice(event.Price, event.Currency)))
+	if event.Description != "" {
+		lines = append(lines, "", event.Description)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SeatsLeft returns count of free seats given count of taken ones
+func (e Event) SeatsLeft(taken int64) int64 {
+	if taken >= e.Capacity {
+		return 0
+	}
+	return e.Capacity - taken
+}
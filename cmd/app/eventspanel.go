@@ -0,0 +1,500 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// steps of event dialog for every dialog mode
+var eventDraftSteps = map[string][]string{
+	"create":     {"date", "time", "timezone", "capacity", "title", "description", "venue", "maplink", "price"},
+	"reschedule": {"date", "time", "timezone"},
+	"capacity":   {"capacity"},
+	"details":    {"title", "description", "venue", "maplink", "price"},
+}
+
+var eventDraftPrompts = map[string]string{
+	"date":        "Введите дату в формате ДД.ММ.ГГГГ (например 28.03.2025)",
+	"time":        "Введите время начала в формате ЧЧ:ММ (например 18:00)",
+	"timezone":    "Введите часовой пояс (например Asia/Dubai)",
+	"capacity":    "Введите количество мест",
+	"title":       "Введите название мероприятия",
+	"description": "Введите описание мероприятия (или - чтобы пропустить)",
+	"venue":       "Введите место проведения и адрес (или - чтобы пропустить)",
+	"maplink":     "Отправьте ссылку на карту (или - чтобы пропустить)",
+	"price":       "Введите цену, например 150 или 150.50 AED, для оплаты звёздами - 100 XTR (0 - бесплатно)",
+}
+
+// answer which clears optional value when editing event, "-" keeps current value there
+const eventDraftClearToken = "unset"
+
+// optional steps may be skipped with "-" when creating event, or cleared with eventDraftClearToken when editing
+var eventDraftOptional = map[string]bool{
+	"description": true,
+	"venue":       true,
+	"maplink":     true,
+}
+
+func handleEventsPanel(bc BotController, user User) {
+	events, _ := bc.GetAllEvents()
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, event := range events {
+		label := formatEventDate(event)
+		if event.Hidden {
+			label += " (скрыто)"
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, "eventview:"+strconv.FormatInt(event.ID, 10)),
+		))
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Создать мероприятие", "eventnew")),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Назад", "panel")),
+	)
+	sendMessageKeyboard(bc, user.ID, "Мероприятия", tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func handleEventView(bc BotController, user User, eventID int64) {
+	event, err := bc.GetEvent(eventID)
+	if err != nil {
+		sendMessage(bc, user.ID, "Мероприятие не найдено")
+		return
+	}
+	taken, _ := bc.CountReservationsByEventID(event.ID)
+	hidden := "нет"
+	hideLabel := "Скрыть"
+	if event.Hidden {
+		hidden = "да"
+		hideLabel = "Показать"
+	}
+	text := fmt.Sprintf("Мероприятие #%d\n%s\nЧасовой пояс: %s\nМест занято: %d/%d\nОплата: %s\nНапоминания за: %s\nСкрыто: %s",
+		event.ID, eventDetails(defaultLocale, event), event.Timezone, taken, event.Capacity, getPaymentProvider(event).Name(),
+		formatReminderOffsets(eventReminderOffsets(bc, event)), hidden)
+
+	id := strconv.FormatInt(event.ID, 10)
+	kbd := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Перенести", "eventreschedule:"+id)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Изменить количество мест", "eventcapacity:"+id)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Изменить описание и цену", "eventdetails:"+id)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Способ оплаты", "eventpayment:"+id)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Напоминания", "eventreminders:"+id)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Брони и возвраты", "eventreservations:"+id)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Лист ожидания", "eventwaitlist:"+id)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(hideLabel, "eventhide:"+id)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Удалить", "eventdelete:"+id)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Назад", "events")),
+	)
+	sendMessageKeyboard(bc, user.ID, text, kbd)
+}
+
+func handleEventsCallback(bc BotController, update tgbotapi.Update, user User) {
+	data := update.CallbackQuery.Data
+	if data == "events" {
+		handleEventsPanel(bc, user)
+		return
+	}
+	if data == "eventnew" {
+		startEventDraft(bc, user, EventDraft{UserID: user.ID, Mode: "create", Timezone: "Asia/Dubai", Capacity: 10, Currency: "AED"})
+		return
+	}
+	if data == "eventdraftsave" {
+		saveEventDraft(bc, user)
+		return
+	}
+	if data == "eventdraftcancel" {
+		bc.DeleteEventDraft(user.ID)
+		resetState(bc, user)
+		sendMessage(bc, user.ID, "Отменено")
+		return
+	}
+
+	tokens := strings.Split(data, ":")
+	if len(tokens) < 2 {
+		return
+	}
+	eventID, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing event id: %s\n", err)
+		return
+	}
+	event, err := bc.GetEvent(eventID)
+	if err != nil {
+		sendMessage(bc, user.ID, "Мероприятие не найдено")
+		return
+	}
+
+	switch tokens[0] {
+	case "eventview":
+		handleEventView(bc, user, eventID)
+	case "eventwaitlist":
+		handleWaitlistPanel(bc, user, eventID)
+	case "eventreservations":
+		handleEventReservations(bc, user, event)
+	case "eventrefundall":
+		sendMessageKeyboard(bc, user.ID, "Отменить мероприятие "+formatEventDate(event)+" и вернуть оплату всем участникам?",
+			tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Да, отменить", "eventrefundallconfirm:"+tokens[1]),
+				tgbotapi.NewInlineKeyboardButtonData("Нет", "eventview:"+tokens[1]),
+			)),
+		)
+	case "eventrefundallconfirm":
+		refundEvent(bc, user, event)
+	case "eventpayment":
+		rows := [][]tgbotapi.InlineKeyboardButton{}
+		for _, key := range paymentProviderKeys {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+				paymentProviders[key].Name(), "eventpaymentset:"+tokens[1]+":"+key,
+			)))
+		}
+		sendMessageKeyboard(bc, user.ID, "Выберите способ оплаты", tgbotapi.NewInlineKeyboardMarkup(rows...))
+	case "eventpaymentset":
+		if len(tokens) < 3 {
+			return
+		}
+		if _, ok := paymentProviders[tokens[2]]; !ok {
+			return
+		}
+		event.PaymentProvider = tokens[2]
+		bc.UpdateEvent(event)
+		handleEventView(bc, user, eventID)
+	case "eventreschedule", "eventcapacity", "eventdetails":
+		local := event.LocalDate()
+		draft := EventDraft{
+			UserID:      user.ID,
+			EventID:     event.ID,
+			Mode:        strings.TrimPrefix(tokens[0], "event"),
+			Date:        local.Format("02.01.2006"),
+			Time:        local.Format("15:04"),
+			Timezone:    event.Timezone,
+			Capacity:    event.Capacity,
+			Title:       event.Title,
+			Description: event.Description,
+			Venue:       event.Venue,
+			MapLink:     event.MapLink,
+			Price:       event.Price,
+			Currency:    event.Currency,
+		}
+		startEventDraft(bc, user, draft)
+	case "eventreminders":
+		setState(bc, user, "eventreminders", StatePayload{EventID: event.ID})
+		sendMessage(bc, user.ID, fmt.Sprintf("Сейчас напоминания за: %s\nВведите интервалы до начала через запятую, например: 24h, 8h, 30m\n\"-\" - по умолчанию (%s)",
+			formatReminderOffsets(eventReminderOffsets(bc, event)), bc.cfg.ReminderOffsets))
+	case "eventhide":
+		event.Hidden = !event.Hidden
+		bc.UpdateEvent(event)
+		handleEventView(bc, user, eventID)
+	case "eventdelete":
+		taken, _ := bc.CountReservationsByEventID(event.ID)
+		if taken > 0 {
+			sendMessage(bc, user.ID, fmt.Sprintf("Нельзя удалить мероприятие с бронями (%d), скройте его вместо удаления", taken))
+			return
+		}
+		sendMessageKeyboard(bc, user.ID, "Удалить мероприятие "+formatEventDate(event)+"?",
+			tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Да, удалить", "eventdeleteconfirm:"+tokens[1]),
+				tgbotapi.NewInlineKeyboardButtonData("Нет", "eventview:"+tokens[1]),
+			)),
+		)
+	case "eventdeleteconfirm":
+		taken, _ := bc.CountReservationsByEventID(event.ID)
+		if taken > 0 {
+			sendMessage(bc, user.ID, "Нельзя удалить мероприятие с бронями")
+			return
+		}
+		if err := bc.DeleteEvent(event.ID); err != nil {
+			log.Printf("Error deleting event: %s\n", err)
+			sendMessage(bc, user.ID, "Something went wrong, try again...")
+			return
+		}
+		sendMessage(bc, user.ID, "Мероприятие удалено")
+		handleEventsPanel(bc, user)
+	}
+}
+
+func startEventDraft(bc BotController, user User, draft EventDraft) {
+	if err := bc.SaveEventDraft(draft); err != nil {
+		log.Printf("Error saving event draft: %s\n", err)
+		return
+	}
+	askEventDraftStep(bc, user, draft, eventDraftSteps[draft.Mode][0])
+}
+
+func askEventDraftStep(bc BotController, user User, draft EventDraft, step string) {
+	setState(bc, user, "eventdraft", StatePayload{Step: step})
+	prompt := eventDraftPrompts[step]
+	if draft.EventID != 0 {
+		prompt += fmt.Sprintf("\nОтправьте - чтобы оставить текущее значение (%s)", eventDraftValue(draft, step))
+		if eventDraftOptional[step] {
+			prompt += "\nОтправьте " + eventDraftClearToken + " чтобы очистить"
+		}
+	}
+	prompt += "\nSay /cancel to cancel action"
+	sendMessage(bc, user.ID, prompt)
+}
+
+func eventDraftValue(draft EventDraft, step string) string {
+	switch step {
+	case "date":
+		return draft.Date
+	case "time":
+		return draft.Time
+	case "timezone":
+		return draft.Timezone
+	case "capacity":
+		return strconv.FormatInt(draft.Capacity, 10)
+	case "title":
+		return draft.Title
+	case "description":
+		return draft.Description
+	case "venue":
+		return draft.Venue
+	case "maplink":
+		return draft.MapLink
+	case "price":
+		return formatPrice(draft.Price, draft.Currency)
+	}
+	return ""
+}
+
+func handleEventDraftMessage(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+	step := payload.Step
+	draft, err := bc.GetEventDraft(user.ID)
+	if err != nil {
+		resetState(bc, user)
+		sendMessage(bc, user.ID, "Черновик не найден, начните заново через /panel")
+		return
+	}
+
+	text := strings.TrimSpace(update.Message.Text)
+	if eventDraftOptional[step] && ((text == "-" && draft.EventID == 0) || (text == eventDraftClearToken && draft.EventID != 0)) {
+		text = ""
+	}
+	if text != "-" || draft.EventID == 0 {
+		if err := applyEventDraftStep(bc, &draft, step, text); err != nil {
+			sendMessage(bc, user.ID, err.Error())
+			return
+		}
+		bc.UpdateEventDraft(draft)
+	}
+
+	steps := eventDraftSteps[draft.Mode]
+	for i, s := range steps {
+		if s == step && i+1 < len(steps) {
+			askEventDraftStep(bc, user, draft, steps[i+1])
+			return
+		}
+	}
+	confirmEventDraft(bc, user, draft)
+}
+
+func applyEventDraftStep(bc BotController, draft *EventDraft, step string, text string) error {
+	switch step {
+	case "date":
+		if _, err := time.Parse("02.01.2006", text); err != nil {
+			return errors.New("Неверный формат даты, ожидается ДД.ММ.ГГГГ")
+		}
+		draft.Date = text
+	case "time":
+		if _, err := time.Parse("15:04", text); err != nil {
+			return errors.New("Неверный формат времени, ожидается ЧЧ:ММ")
+		}
+		draft.Time = text
+	case "timezone":
+		if _, err := time.LoadLocation(text); err != nil || text == "" || text == "Local" {
+			return errors.New("Неизвестный часовой пояс, пример: Asia/Dubai")
+		}
+		draft.Timezone = text
+	case "capacity":
+		capacity, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || capacity <= 0 {
+			return errors.New("Количество мест должно быть положительным числом")
+		}
+		if draft.EventID != 0 {
+			taken, _ := bc.CountReservationsByEventID(draft.EventID)
+			if capacity < taken {
+				return fmt.Errorf("Уже забронировано %d мест, нельзя указать меньше", taken)
+			}
+		}
+		draft.Capacity = capacity
+	case "title":
+		if text == "" || text == "-" {
+			return errors.New("Название не может быть пустым")
+		}
+		draft.Title = text
+	case "description":
+		draft.Description = text
+	case "venue":
+		draft.Venue = text
+	case "maplink":
+		if text != "" && !strings.HasPrefix(text, "http://") && !strings.HasPrefix(text, "https://") {
+			return errors.New("Ссылка должна начинаться с http:// или https://")
+		}
+		draft.MapLink = text
+	case "price":
+		price, currency, err := parsePrice(text, draft.Currency)
+		if err != nil {
+			return err
+		}
+		draft.Price = price
+		draft.Currency = currency
+	}
+	return nil
+}
+
+func eventDraftDate(draft EventDraft) (time.Time, error) {
+	loc, err := time.LoadLocation(draft.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.ParseInLocation("02.01.2006 15:04", draft.Date+" "+draft.Time, loc)
+}
+
+// validateEventDraft checks values which depend on each other or on other events
+func validateEventDraft(bc BotController, draft EventDraft) (time.Time, error) {
+	date, err := eventDraftDate(draft)
+	if err != nil {
+		return date, errors.New("Неверная дата или часовой пояс")
+	}
+	if draft.Mode != "capacity" && date.Before(time.Now()) {
+		return date, errors.New("Дата мероприятия уже прошла")
+	}
+	events, _ := bc.GetAllEvents()
+	for _, event := range events {
+		if event.ID != draft.EventID && event.Date.Equal(date) {
+			return date, fmt.Errorf("На это время уже есть мероприятие #%d", event.ID)
+		}
+	}
+	return date, nil
+}
+
+func confirmEventDraft(bc BotController, user User, draft EventDraft) {
+	setState(bc, user, "eventdraft", StatePayload{Step: "confirm"})
+	date, err := validateEventDraft(bc, draft)
+	if err != nil {
+		sendMessage(bc, user.ID, err.Error())
+		askEventDraftStep(bc, user, draft, eventDraftSteps[draft.Mode][0])
+		return
+	}
+
+	title := "Новое мероприятие"
+	if draft.EventID != 0 {
+		title = fmt.Sprintf("Мероприятие #%d", draft.EventID)
+	}
+	preview := eventDraftToEvent(draft, date)
+	text := fmt.Sprintf("%s\n%s\nЧасовой пояс: %s\nМест: %d\n\nСохранить?",
+		title, eventDetails(defaultLocale, preview), draft.Timezone, draft.Capacity)
+	sendMessageKeyboard(bc, user.ID, text, tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Сохранить", "eventdraftsave"),
+		tgbotapi.NewInlineKeyboardButtonData("Отмена", "eventdraftcancel"),
+	)))
+}
+
+func saveEventDraft(bc BotController, user User) {
+	draft, err := bc.GetEventDraft(user.ID)
+	if err != nil || user.State != "eventdraft" || user.Payload().Step != "confirm" {
+		sendMessage(bc, user.ID, "Черновик не найден, начните заново через /panel")
+		return
+	}
+	date, err := validateEventDraft(bc, draft)
+	if err != nil {
+		sendMessage(bc, user.ID, err.Error())
+		return
+	}
+
+	var event Event
+	rescheduled := true
+	if draft.EventID == 0 {
+		event, err = bc.CreateEvent(eventDraftToEvent(draft, date))
+	} else {
+		event, err = bc.GetEvent(draft.EventID)
+		if err == nil {
+			rescheduled = !event.Date.Equal(date)
+			updated := eventDraftToEvent(draft, date)
+			updated.Model = event.Model
+			updated.ID = event.ID
+			updated.Hidden = event.Hidden
+			event = updated
+			err = bc.UpdateEvent(event)
+		}
+	}
+	if err != nil {
+		log.Printf("Error saving event: %s\n", err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+
+	if rescheduled {
+		// reminders are sent again for new date
+		bc.ResetReminderDeliveries(event.ID)
+		scheduleEventReminders(bc, event)
+	}
+
+	bc.DeleteEventDraft(user.ID)
+	resetState(bc, user)
+	sendMessage(bc, user.ID, "Мероприятие сохранено")
+	handleEventView(bc, user, event.ID)
+}
+
+func eventDraftToEvent(draft EventDraft, date time.Time) Event {
+	return Event{
+		Date:        &date,
+		Timezone:    draft.Timezone,
+		Capacity:    draft.Capacity,
+		Title:       draft.Title,
+		Description: draft.Description,
+		Venue:       draft.Venue,
+		MapLink:     draft.MapLink,
+		Price:       draft.Price,
+		Currency:    draft.Currency,
+	}
+}
+
+// parsePrice parses "150", "150.50" or "150.50 USD" into minor units,
+// Telegram Stars ("100 XTR") have no minor units
+func parsePrice(text string, defaultCurrency string) (int64, string, error) {
+	errFormat := errors.New("Неверный формат цены, пример: 150 или 150.50 AED")
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, "", errFormat
+	}
+	currency := defaultCurrency
+	if currency == "" {
+		currency = "AED"
+	}
+	if len(fields) == 2 {
+		currency = strings.ToUpper(fields[1])
+		if len(currency) != 3 {
+			return 0, "", errFormat
+		}
+	}
+	amount := strings.Replace(fields[0], ",", ".", 1)
+	if !currencyHasMinorUnits(currency) {
+		stars, err := strconv.ParseInt(amount, 10, 64)
+		if err != nil || stars < 0 {
+			return 0, "", errFormat
+		}
+		return stars, currency, nil
+	}
+	whole, frac, hasFrac := strings.Cut(amount, ".")
+	if hasFrac && (len(frac) == 0 || len(frac) > 2) {
+		return 0, "", errFormat
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || major < 0 {
+		return 0, "", errFormat
+	}
+	minor, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil || minor < 0 {
+		return 0, "", errFormat
+	}
+	return major*100 + minor, currency, nil
+}
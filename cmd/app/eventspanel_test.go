@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEventDetailsClearOptional(t *testing.T) {
+	h, adminID := newContentTestHarness(t)
+	date := time.Now().Add(48 * time.Hour)
+	event, _ := h.bc.CreateEvent(Event{Date: &date, Timezone: "Asia/Dubai", Capacity: 5, Title: "Вечер джаза",
+		Description: "Живая музыка", Venue: "Главный зал", Currency: "AED"})
+
+	h.press(adminID, "eventdetails:"+strconv.FormatInt(event.ID, 10))
+	// title, description, venue, map link, price
+	for _, answer := range []string{"-", "-", eventDraftClearToken, "-", "-"} {
+		h.send(adminID, answer)
+	}
+	h.press(adminID, h.button(h.last(adminID), "eventdraftsave"))
+
+	event, _ = h.bc.GetEvent(event.ID)
+	if event.Venue != "" || event.Description != "Живая музыка" || event.Title != "Вечер джаза" {
+		t.Fatalf("event after edit: %+v", event)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestReservationFlow(t *testing.T) {
+	h := newTestHarness(t)
+	date := time.Now().Add(72 * time.Hour)
+	event, err := h.bc.CreateEvent(Event{Date: &date, Capacity: 5, Price: 15000, Currency: "AED"})
+	if err != nil {
+		t.Fatalf("create event: %s", err)
+	}
+	h.bc.SetBotContent("ask_to_pay", "Оплатите бронь", "")
+	h.bc.SetBotContent("post_payment_message", "Ждём вас!", "")
+
+	const userID = 42
+	h.send(userID, "/start")
+	reserve := h.button(h.last(userID), "reservedate:")
+	if reserve != "reservedate:"+strconv.FormatInt(event.ID, 10) {
+		t.Fatalf("start menu offers %s", reserve)
+	}
+
+	h.press(userID, reserve)
+	if answers := h.fake.find("answerCallbackQuery"); len(answers) != 1 {
+		t.Fatalf("%d callbacks answered, want 1", len(answers))
+	}
+	reservations, _ := h.bc.GetReservationsByEventID(event.ID)
+	if len(reservations) != 1 || reservations[0].UserID != userID || reservations[0].Status != Booked {
+		t.Fatalf("reservations after booking: %+v", reservations)
+	}
+	reservation := reservations[0]
+
+	h.send(userID, "Ivan")
+	invoice := h.last(userID)
+	if invoice.Method != "sendInvoice" || invoice.Params["payload"] != invoicePayload(reservation) {
+		t.Fatalf("last request is %+v, want invoice", invoice)
+	}
+	reservation, _ = h.bc.GetReservationByID(reservation.ID)
+	if reservation.EnteredName != "Ivan" {
+		t.Fatalf("entered name is %q", reservation.EnteredName)
+	}
+
+	h.process(tgbotapi.Update{PreCheckoutQuery: &tgbotapi.PreCheckoutQuery{
+		ID:             "q",
+		From:           &tgbotapi.User{ID: userID},
+		Currency:       "AED",
+		TotalAmount:    15000,
+		InvoicePayload: invoicePayload(reservation),
+	}})
+	answers := h.fake.find("answerPreCheckoutQuery")
+	if len(answers) != 1 || answers[0].Params["ok"] != "true" {
+		t.Fatalf("pre-checkout answers: %+v", answers)
+	}
+
+	payment := userMessage(userID, 100, "")
+	payment.Message.SuccessfulPayment = &tgbotapi.SuccessfulPayment{
+		Currency:                "AED",
+		TotalAmount:             15000,
+		InvoicePayload:          invoicePayload(reservation),
+		TelegramPaymentChargeID: "tg-charge",
+	}
+	h.process(payment)
+	reservation, _ = h.bc.GetReservationByID(reservation.ID)
+	if reservation.Status != Paid || reservation.TelegramChargeID != "tg-charge" {
+		t.Fatalf("reservation after payment: %+v", reservation)
+	}
+	if last := h.last(userID); last.Params["text"] != "Ждём вас!" {
+		t.Fatalf("last message %q, want post payment message", last.Params["text"])
+	}
+	if user := h.bc.GetUser(userID); user.State != StartState {
+		t.Fatalf("user is left in state %s", user.State)
+	}
+}
+
+func TestSoldOutFlow(t *testing.T) {
+	h := newTestHarness(t)
+	date := time.Now().Add(72 * time.Hour)
+	event, _ := h.bc.CreateEvent(Event{Date: &date, Capacity: 1})
+
+	h.send(1, "/start")
+	h.press(1, h.button(h.last(1), "reservedate:"))
+	h.send(1, "Ivan")
+
+	// free seat is taken, next user is offered waitlist
+	h.send(2, "/start")
+	join := h.button(h.last(2), "waitjoin:")
+	h.press(2, join)
+	waitlist, _ := h.bc.GetWaitlist(event.ID)
+	if len(waitlist) != 1 || waitlist[0].UserID != 2 {
+		t.Fatalf("waitlist: %+v", waitlist)
+	}
+}
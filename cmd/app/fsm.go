@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// StartState is state of user who isn't in any dialog
+const StartState = "start"
+
+// how long admin dialogs wait for answer
+const adminDialogTimeout = time.Hour
+
+// StatePayload is data of dialog state, stored with user as JSON
+type StatePayload struct {
+	ReservationID int64  `json:"reservation_id,omitempty"`
+	EventID       int64  `json:"event_id,omitempty"`
+	PromoID       int64  `json:"promo_id,omitempty"`
+	BroadcastID   int64  `json:"broadcast_id,omitempty"`
+	Literal       string `json:"literal,omitempty"`
+	Locale        string `json:"locale,omitempty"`
+	Step          string `json:"step,omitempty"`
+}
+
+// DialogState is a step of dialog, in which user's messages are handled by Handle
+type DialogState struct {
+	Admin   bool                                                    // only effective admins may be in this state
+	Timeout time.Duration                                           // user is returned to start if there is no answer in time, 0 to wait forever
+	Enter   func(bc BotController, user User, payload StatePayload) // optional, called when user comes from other state
+	Handle  func(bc BotController, update tgbotapi.Update, user User, payload StatePayload)
+	Exit    func(bc BotController, user User, payload StatePayload) // optional, called when user goes to other state
+}
+
+var dialogStates map[string]DialogState
+
+// registered in init, as handlers refer to dialogStates themselves
+func init() {
+	dialogStates = map[string]DialogState{
+		StartState: {},
+		"leaveticket": {
+			Timeout: 24 * time.Hour,
+			Enter: func(bc BotController, user User, payload StatePayload) {
+				sendBotContent(bc, user.ID, user.Locale, "leaveticket_message", userTemplateVars(bc, user.ID))
+			},
+			Handle: func(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+				handleTicketMessage(bc, update, user)
+			},
+		},
+		"enternamereservation": {Handle: handleReservationNameMessage},
+		"enterpromo":           {Handle: handlePromoCodeMessage},
+		"paymentreceipt":       {Handle: handlePaymentReceipt},
+		"contentset": {
+			Admin:   true,
+			Timeout: adminDialogTimeout,
+			Enter:   askAsset,
+			Handle:  handleContentSetMessage,
+			Exit: func(bc BotController, user User, payload StatePayload) {
+				bc.DeleteContentDraft(user.ID)
+			},
+		},
+		"eventdraft": {
+			Admin:   true,
+			Timeout: adminDialogTimeout,
+			Handle:  handleEventDraftMessage,
+			Exit: func(bc BotController, user User, payload StatePayload) {
+				bc.DeleteEventDraft(user.ID)
+			},
+		},
+		"eventreminders": {
+			Admin:   true,
+			Timeout: adminDialogTimeout,
+			Handle:  handleEventRemindersMessage,
+		},
+		"promodraft": {
+			Admin:   true,
+			Timeout: adminDialogTimeout,
+			Handle:  handlePromoDraftMessage,
+			Exit: func(bc BotController, user User, payload StatePayload) {
+				promo, err := bc.GetPromoCode(payload.PromoID)
+				if err == nil && promo.Draft {
+					bc.DeletePromoCode(promo.ID)
+				}
+			},
+		},
+		"broadcastcompose": {
+			Admin:   true,
+			Timeout: adminDialogTimeout,
+			Enter: func(bc BotController, user User, payload StatePayload) {
+				sendMessage(bc, user.ID, "Send me message to broadcast (text, photo or forwarded post).\nSay /cancel to cancel action")
+			},
+			Handle: handleBroadcastComposeMessage,
+		},
+		"broadcastinactive": {
+			Admin:   true,
+			Timeout: adminDialogTimeout,
+			Handle:  handleBroadcastInactiveMessage,
+		},
+	}
+}
+
+// Payload returns payload of user's current state
+func (u User) Payload() StatePayload {
+	var payload StatePayload
+	if u.StatePayload != "" {
+		if err := json.Unmarshal([]byte(u.StatePayload), &payload); err != nil {
+			log.Printf("Error parsing state payload of %d: %s\n", u.ID, err)
+		}
+	}
+	return payload
+}
+
+// ContentLocale is locale of edited literal, states saved before locales were added edit default one
+func (p StatePayload) ContentLocale() string {
+	if p.Locale == "" {
+		return defaultLocale
+	}
+	return p.Locale
+}
+
+// InReservationState tells if user is in state name about reservation, used to leave
+// dialogs about reservation when it changes in background
+func (u User) InReservationState(name string, reservationID int64) bool {
+	return u.State == name && u.Payload().ReservationID == reservationID
+}
+
+// setState moves user to state name. Exit of old state and Enter of new one are called
+// only if state changes, moving within same state just replaces payload.
+func setState(bc BotController, user User, name string, payload StatePayload) User {
+	next, ok := dialogStates[name]
+	if !ok {
+		log.Printf("Error: unknown dialog state %s\n", name)
+		return user
+	}
+	changed := user.State != name
+	if prev, ok := dialogStates[user.State]; ok && changed && prev.Exit != nil {
+		prev.Exit(bc, user, user.Payload())
+	}
+
+	data, _ := json.Marshal(payload)
+	var expiresAt *time.Time
+	if next.Timeout > 0 {
+		t := time.Now().Add(next.Timeout)
+		expiresAt = &t
+	}
+	bc.db.Model(&user).Updates(map[string]interface{}{
+		"state":            name,
+		"state_payload":    string(data),
+		"state_expires_at": expiresAt,
+	})
+	user.State = name
+	user.StatePayload = string(data)
+	user.StateExpiresAt = expiresAt
+
+	if changed && next.Enter != nil {
+		next.Enter(bc, user, payload)
+	}
+	return user
+}
+
+// resetState returns user to start state
+func resetState(bc BotController, user User) User {
+	return setState(bc, user, StartState, StatePayload{})
+}
+
+// handleStateMessage passes message to handler of user's state, returns false if state
+// doesn't handle messages
+func handleStateMessage(bc BotController, update tgbotapi.Update, user User) (User, bool) {
+	if user.State == "" {
+		return user, false
+	}
+	state, ok := dialogStates[user.State]
+	if !ok {
+		// e.g. state saved by older version
+		log.Printf("Unknown state of user %d: %s\n", user.ID, user.State)
+		return resetState(bc, user), false
+	}
+	if state.Handle == nil {
+		return user, false
+	}
+	if state.Admin && !user.IsEffectiveAdmin() {
+		return resetState(bc, user), false
+	}
+	if user.StateExpiresAt != nil && user.StateExpiresAt.Before(time.Now()) {
+		resetState(bc, user)
+		sendMessage(bc, user.ID, tr(user.Locale, "Время ожидания истекло, начните заново"))
+		return user, true
+	}
+
+	state.Handle(bc, update, user, user.Payload())
+	return user, true
+}
+
+func handleCancelCommand(bc BotController, update tgbotapi.Update, user User) {
+	if user.State == StartState || user.State == "" {
+		sendMessage(bc, user.ID, tr(user.Locale, "Нечего отменять"))
+		return
+	}
+	resetState(bc, user)
+	sendMessage(bc, user.ID, tr(user.Locale, "Отменено"))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// registerTestState adds state for duration of test
+func registerTestState(t *testing.T, name string, state DialogState) {
+	t.Helper()
+	dialogStates[name] = state
+	t.Cleanup(func() { delete(dialogStates, name) })
+}
+
+func newStateTestBotController(t *testing.T) (BotController, *fakeBotAPI) {
+	t.Helper()
+	bc := newTestBotController(t)
+	bot, fake := newFakeBotAPI(t)
+	bc.bot = bot
+	return bc, fake
+}
+
+func TestSetStateEnterExit(t *testing.T) {
+	bc, _ := newStateTestBotController(t)
+	entered, exited := 0, 0
+	registerTestState(t, "testdialog", DialogState{
+		Enter: func(bc BotController, user User, payload StatePayload) { entered++ },
+		Handle: func(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+		},
+		Exit: func(bc BotController, user User, payload StatePayload) {
+			exited++
+			if payload.Step != "second" {
+				t.Errorf("exit got payload %+v", payload)
+			}
+		},
+	})
+
+	user := bc.GetUser(1)
+	user = setState(bc, user, "testdialog", StatePayload{Step: "first", EventID: 5})
+	user = setState(bc, user, "testdialog", StatePayload{Step: "second"})
+	if entered != 1 || exited != 0 {
+		t.Fatalf("moving within state: entered %d, exited %d", entered, exited)
+	}
+
+	stored := bc.GetUser(1)
+	if stored.State != "testdialog" || stored.Payload() != (StatePayload{Step: "second"}) {
+		t.Fatalf("stored state %s %+v", stored.State, stored.Payload())
+	}
+
+	resetState(bc, user)
+	if entered != 1 || exited != 1 {
+		t.Fatalf("leaving state: entered %d, exited %d", entered, exited)
+	}
+	if bc.GetUser(1).State != StartState {
+		t.Fatal("user is not returned to start")
+	}
+}
+
+func TestHandleStateMessage(t *testing.T) {
+	bc, _ := newStateTestBotController(t)
+	var handled StatePayload
+	registerTestState(t, "testdialog", DialogState{
+		Handle: func(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+			handled = payload
+		},
+	})
+
+	user := setState(bc, bc.GetUser(1), "testdialog", StatePayload{ReservationID: 7})
+	if _, ok := handleStateMessage(bc, userMessage(1, 1, "hi"), bc.GetUser(1)); !ok || handled.ReservationID != 7 {
+		t.Fatalf("message is not handled by state, payload %+v", handled)
+	}
+
+	resetState(bc, user)
+	if _, ok := handleStateMessage(bc, userMessage(1, 2, "hi"), bc.GetUser(1)); ok {
+		t.Fatal("message is handled in start state")
+	}
+}
+
+func TestStateTimeout(t *testing.T) {
+	bc, _ := newStateTestBotController(t)
+	called := false
+	registerTestState(t, "testdialog", DialogState{
+		Timeout: time.Minute,
+		Handle: func(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+			called = true
+		},
+	})
+
+	user := setState(bc, bc.GetUser(1), "testdialog", StatePayload{})
+	if user.StateExpiresAt == nil {
+		t.Fatal("timeout is not set")
+	}
+	bc.db.Model(&user).Update("state_expires_at", time.Now().Add(-time.Second))
+
+	if _, ok := handleStateMessage(bc, userMessage(1, 1, "late"), bc.GetUser(1)); !ok || called {
+		t.Fatalf("expired state: handled %v, handler called %v", ok, called)
+	}
+	if bc.GetUser(1).State != StartState {
+		t.Fatal("user is not returned to start after timeout")
+	}
+}
+
+func TestAdminStateRequiresAdmin(t *testing.T) {
+	bc, _ := newStateTestBotController(t)
+	user := setState(bc, bc.GetUser(1), "contentset", StatePayload{Literal: "start"})
+
+	if _, ok := handleStateMessage(bc, userMessage(1, 1, "new text"), user); ok {
+		t.Fatal("admin state handled message of non-admin")
+	}
+	if _, err := bc.GetBotContentVerbose("start"); err == nil {
+		t.Fatal("literal is changed by non-admin")
+	}
+	if bc.GetUser(1).State != StartState {
+		t.Fatal("non-admin is not returned to start")
+	}
+}
+
+func TestUnknownStateReset(t *testing.T) {
+	bc, _ := newStateTestBotController(t)
+	user := bc.GetUser(1)
+	// state format of older versions
+	bc.db.Model(&user).Update("state", "enternamereservation:5")
+
+	if _, ok := handleStateMessage(bc, userMessage(1, 1, "name"), bc.GetUser(1)); ok {
+		t.Fatal("unknown state handled message")
+	}
+	if bc.GetUser(1).State != StartState {
+		t.Fatal("unknown state is not reset")
+	}
+}
+
+func TestCancelRunsExit(t *testing.T) {
+	bc, fake := newStateTestBotController(t)
+	user := bc.GetUser(1)
+	bc.db.Model(&user).Update("role_bitmask", 0b11)
+	user = bc.GetUser(1)
+
+	startEventDraft(bc, user, EventDraft{UserID: user.ID, Mode: "create"})
+	user = bc.GetUser(1)
+	if user.State != "eventdraft" || user.Payload().Step != eventDraftSteps["create"][0] {
+		t.Fatalf("draft dialog is not started: %s %+v", user.State, user.Payload())
+	}
+
+	handleCancelCommand(bc, userMessage(1, 1, "/cancel"), user)
+	if _, err := bc.GetEventDraft(user.ID); err == nil {
+		t.Fatal("draft is not deleted on cancel")
+	}
+	if bc.GetUser(1).State != StartState {
+		t.Fatal("user is not returned to start")
+	}
+	sent := fake.find("sendMessage")
+	if last := sent[len(sent)-1].Params["text"]; last != "Отменено" {
+		t.Fatalf("last message %q", last)
+	}
+}
+
+func TestReservationNameTransition(t *testing.T) {
+	bc, _ := newStateTestBotController(t)
+	event := createTestEvent(t, bc, 5) // free event
+	reservation, err := bc.BookSeat(1, event.ID, "Не указано")
+	if err != nil {
+		t.Fatalf("book seat: %s", err)
+	}
+
+	user := bc.GetUser(1)
+	startReservationName(bc, user, event, reservation)
+	user = bc.GetUser(1)
+	if !user.InReservationState("enternamereservation", reservation.ID) {
+		t.Fatalf("user is in state %s %+v", user.State, user.Payload())
+	}
+
+	handleDefaultMessage(bc, userMessage(1, 1, "Ivan"), user)
+	reservation, _ = bc.GetReservationByID(reservation.ID)
+	if reservation.EnteredName != "Ivan" || reservation.Status != Paid {
+		t.Fatalf("reservation is %+v", reservation)
+	}
+	if bc.GetUser(1).State != StartState {
+		t.Fatal("user is not returned to start after free reservation")
+	}
+}
@@ -23,10 +23,12 @@ func (bc *BotController) SyncPaidUsersToSheet() error {
 	}
 
 	var values [][]interface{}
-	values = append(values, []interface{}{"Телеграм ID", "Имя", "Фамилия", "Никнейм", "Указанное имя", "Дата", "Телефон", "Статус"})
+	values = append(values, []interface{}{"Телеграм ID", "Имя", "Фамилия", "Никнейм", "Указанное имя", "Дата", "Телефон", "Статус", "Мероприятие", "Место", "Цена", "Промокод"})
 
 	for _, reservation := range reservations {
-		if reservation.Status != Paid {
+		payAtDoor := reservation.Status == Booked && reservation.PaymentProvider == "door"
+		paid := reservation.Status == Paid || reservation.Status == RefundRequested || reservation.Status == Refunded
+		if !paid && !payAtDoor {
 			continue
 		}
 
@@ -35,8 +37,17 @@ func (bc *BotController) SyncPaidUsersToSheet() error {
 		ui, _ := bc.GetUserInfo(uid)
 		event, _ := bc.GetEvent(reservation.EventID)
 		status := ReservationStatusString[reservation.Status]
+		if payAtDoor {
+			status += " (оплата на месте)"
+		}
+		date := event.LocalDate()
+		promo := ""
+		if reservation.PromoCodeID != 0 {
+			p, _ := bc.GetPromoCode(reservation.PromoCodeID)
+			promo = p.Code
+		}
 
-		values = append(values, []interface{}{user.ID, ui.FirstName, ui.LastName, ui.Username, reservation.EnteredName, formatDate(event.Date), "", status})
+		values = append(values, []interface{}{user.ID, ui.FirstName, ui.LastName, ui.Username, reservation.EnteredName, formatDate(&date), "", status, event.Title, event.Venue, formatPrice(bc.ReservationPrice(reservation, event), event.Currency), promo})
 	}
 
 	// Prepare the data to be written to the sheet
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeBotAPI is an in-memory Bot API which records all requests
+type fakeBotAPI struct {
+	mu       sync.Mutex
+	requests []fakeBotRequest
+	results  map[string]interface{} // overrides default results of methods
+}
+
+func (f *fakeBotAPI) setResult(method string, result interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[method] = result
+}
+
+type fakeBotRequest struct {
+	Method string
+	Params map[string]string
+}
+
+// Do implements tgbotapi.HTTPClient, so bot's requests never leave the process
+func (f *fakeBotAPI) Do(r *http.Request) (*http.Response, error) {
+	r.ParseMultipartForm(1 << 20) // falls back to url-encoded form
+	params := map[string]string{}
+	for k := range r.Form {
+		params[k] = r.Form.Get(k)
+	}
+	method := path.Base(r.URL.Path)
+	f.mu.Lock()
+	f.requests = append(f.requests, fakeBotRequest{Method: method, Params: params})
+	messageID := len(f.requests)
+	override, overridden := f.results[method]
+	f.mu.Unlock()
+
+	var result interface{} = true
+	switch method {
+	case "getMe":
+		result = tgbotapi.User{ID: 1, IsBot: true, UserName: "testbot"}
+	case "sendMessage", "sendPhoto", "sendVideo", "sendAnimation", "sendDocument", "sendInvoice":
+		chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+		result = tgbotapi.Message{MessageID: messageID, Chat: &tgbotapi.Chat{ID: chatID}}
+	case "copyMessage":
+		result = tgbotapi.MessageID{MessageID: messageID}
+	}
+	if overridden {
+		result = override
+	}
+	body, _ := json.Marshal(map[string]interface{}{"ok": true, "result": result})
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}, nil
+}
+
+func newFakeBotAPI(t *testing.T) (TelegramClient, *fakeBotAPI) {
+	t.Helper()
+	fake := &fakeBotAPI{results: map[string]interface{}{}}
+	bot, err := tgbotapi.NewBotAPIWithClient("token", tgbotapi.APIEndpoint, fake)
+	if err != nil {
+		t.Fatalf("create bot: %s", err)
+	}
+	return botAPIClient{bot}, fake
+}
+
+func (f *fakeBotAPI) find(method string) []fakeBotRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var found []fakeBotRequest
+	for _, r := range f.requests {
+		if r.Method == method {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// sentTo returns requests addressed to chat, in order they were made
+func (f *fakeBotAPI) sentTo(chatID int64) []fakeBotRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := strconv.FormatInt(chatID, 10)
+	var found []fakeBotRequest
+	for _, r := range f.requests {
+		if r.Params["chat_id"] == id {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func userMessage(userID int64, messageID int, text string) tgbotapi.Update {
+	return tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: messageID,
+		From:      &tgbotapi.User{ID: userID},
+		Chat:      &tgbotapi.Chat{ID: userID, Type: "private"},
+		Text:      text,
+	}}
+}
+
+func commandMessage(userID int64, messageID int, text string) tgbotapi.Update {
+	update := userMessage(userID, messageID, text)
+	command, _, _ := strings.Cut(text, " ")
+	update.Message.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Length: len(command)}}
+	return update
+}
+
+func callbackUpdate(userID int64, data string) tgbotapi.Update {
+	return tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "cb",
+		From:    &tgbotapi.User{ID: userID},
+		Message: &tgbotapi.Message{MessageID: 1, Chat: &tgbotapi.Chat{ID: userID, Type: "private"}},
+		Data:    data,
+	}}
+}
+
+// testHarness feeds updates through the whole bot: router, handlers, in-memory DB and fake Telegram
+type testHarness struct {
+	t        *testing.T
+	bc       BotController
+	fake     *fakeBotAPI
+	updateID int
+}
+
+func newTestHarness(t *testing.T) *testHarness {
+	t.Helper()
+	// shared cache keeps database alive between connections of pool
+	db, err := OpenDB("file:" + url.PathEscape(t.Name()) + "?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("open db: %s", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	bot, fake := newFakeBotAPI(t)
+	return &testHarness{t: t, bc: BotController{db: db, bot: bot}, fake: fake}
+}
+
+func (h *testHarness) process(update tgbotapi.Update) {
+	h.updateID++
+	update.UpdateID = h.updateID
+	ProcessUpdate(h.bc, update)
+}
+
+// send processes text message of user, text starting with "/" is sent as command
+func (h *testHarness) send(userID int64, text string) {
+	if strings.HasPrefix(text, "/") {
+		h.process(commandMessage(userID, h.updateID+1, text))
+	} else {
+		h.process(userMessage(userID, h.updateID+1, text))
+	}
+}
+
+// press processes press of inline button with callback data
+func (h *testHarness) press(userID int64, data string) {
+	h.process(callbackUpdate(userID, data))
+}
+
+// last returns last request to chat, test fails if there are none
+func (h *testHarness) last(chatID int64) fakeBotRequest {
+	h.t.Helper()
+	sent := h.fake.sentTo(chatID)
+	if len(sent) == 0 {
+		h.t.Fatalf("nothing is sent to %d", chatID)
+	}
+	return sent[len(sent)-1]
+}
+
+// button returns callback data of first inline button of request which data starts with prefix
+func (h *testHarness) button(r fakeBotRequest, prefix string) string {
+	h.t.Helper()
+	var markup tgbotapi.InlineKeyboardMarkup
+	json.Unmarshal([]byte(r.Params["reply_markup"]), &markup)
+	for _, row := range markup.InlineKeyboard {
+		for _, b := range row {
+			if b.CallbackData != nil && strings.HasPrefix(*b.CallbackData, prefix) {
+				return *b.CallbackData
+			}
+		}
+	}
+	h.t.Fatalf("no %s button in %s", prefix, r.Params["reply_markup"])
+	return ""
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// defaultLocale is language of content set before locales were added, other locales fall back to it
+const defaultLocale = "ru"
+
+// order of languages in keyboards
+var locales = []string{"ru", "en"}
+
+var localeNames = map[string]string{
+	"ru": "Русский",
+	"en": "English",
+}
+
+// Telegram language codes of users who are likely to prefer russian
+var russianLanguageCodes = map[string]bool{"ru": true, "uk": true, "be": true, "kk": true}
+
+// detectLocale chooses locale by language_code of Telegram user
+func detectLocale(languageCode string) string {
+	code, _, _ := strings.Cut(strings.ToLower(languageCode), "-")
+	if code == "" || russianLanguageCodes[code] {
+		return defaultLocale
+	}
+	return "en"
+}
+
+// localeChain is list of locales to look content up in, from the most preferred
+func localeChain(locale string) []string {
+	if locale == "" || locale == defaultLocale {
+		return []string{defaultLocale}
+	}
+	return []string{locale, defaultLocale}
+}
+
+func isLocale(locale string) bool {
+	_, ok := localeNames[locale]
+	return ok
+}
+
+// translations of texts which aren't editable in panel, by locale and russian text
+var translations = map[string]map[string]string{
+	"en": {
+		// week days
+		"ВС": "Sun", "ПН": "Mon", "ВТ": "Tue", "СР": "Wed", "ЧТ": "Thu", "ПТ": "Fri", "СБ": "Sat",
+
+		// reservation statuses
+		"Забронировано":       "Booked",
+		"Оплачено":            "Paid",
+		"Возврат выполняется": "Refund in progress",
+		"Отменено":            "Cancelled",
+		"Истекло":             "Expired",
+		"Запрошен возврат":    "Refund requested",
+		"Возвращено":          "Refunded",
+
+		// start menu and booking
+		"Пойду": "I'll go",
+		"в":     "at",
+		" (Распродано, лист ожидания)":                  " (Sold out, waitlist)",
+		"Мои бронирования":                              "My bookings",
+		"Вы уже забронировали место на это мероприятие": "You have already booked a seat for this event",
+		"Канал": "Channel",
+		"Я подписался, проверить":                "I've subscribed, check",
+		"Время ожидания истекло, начните заново": "Time is out, please start again",
+		"Нечего отменять":                        "Nothing to cancel",
+		"Выберите язык":                          "Choose language",
+		"Язык изменён":                           "Language is changed",
+
+		// event details
+		"Бесплатно":   "Free",
+		"Дата: ":      "Date: ",
+		"Место: ":     "Venue: ",
+		"Карта: ":     "Map: ",
+		"Стоимость: ": "Price: ",
+
+		// bookings
+		"\nИмя: %s\nСтатус: %s":  "\nName: %s\nStatus: %s",
+		"Отменить ":              "Cancel ",
+		"Вернуть оплату ":        "Refund ",
+		"У вас нет бронирований": "You have no bookings",
+		"Ваши бронирования:\n\n": "Your bookings:\n\n",
+		"Эту бронь уже нельзя отменить, свяжитесь с поддержкой": "This booking can't be cancelled anymore, please contact support",
+		"Отменить бронь на %s?":     "Cancel booking for %s?",
+		"Да, отменить":              "Yes, cancel",
+		"Нет":                       "No",
+		"Бронь отменена":            "Booking is cancelled",
+		"%s\nБронь действует до %s": "%s\nBooking is held until %s",
+
+		// payments
+		"%s\n\nСумма: %s": "%s\n\nAmount: %s",
+		"Пожалуйста, отправьте фото чека": "Please send photo of the receipt",
+		"Бронирование":                    "Booking",
+		"%s, имя: %s":                     "%s, name: %s",
+		"Оплата получена, но бронь уже недействительна. Мы свяжемся с вами для возврата средств": "Payment is received, but booking is no longer valid. We will contact you to refund it",
+		"Возврат по этой брони уже невозможен, свяжитесь с поддержкой":                           "This booking can't be refunded anymore, please contact support",
+		"Отменить бронь на %s и вернуть оплату?":                                                 "Cancel booking for %s and refund payment?",
+		"Да, вернуть": "Yes, refund",
+
+		// promo codes
+		"Сумма к оплате: %s\nЕсть промокод?":               "Amount to pay: %s\nDo you have a promo code?",
+		"Ввести промокод":                                  "Enter promo code",
+		"Перейти к оплате":                                 "Proceed to payment",
+		"Введите промокод":                                 "Enter promo code",
+		"Промокод применён, сумма к оплате: ":              "Promo code is applied, amount to pay: ",
+		"Промокод больше недоступен":                       "Promo code is no longer available",
+		"Промокод не найден":                               "Promo code is not found",
+		"Срок действия промокода истёк или ещё не начался": "Promo code has expired or is not active yet",
+		"Промокод не действует для этого мероприятия":      "Promo code is not valid for this event",
+
+		// waitlist
+		"Встать в лист ожидания":                  "Join waitlist",
+		"%s\nВаше место в очереди: %d":            "%s\nYour place in queue: %d",
+		"%s\n\n%s\n\nПредложение действует до %s": "%s\n\n%s\n\nOffer is valid until %s",
+		"Принять":                "Accept",
+		"Отказаться":             "Decline",
+		"Вы отказались от места": "You have declined the seat",
+
+		// tickets
+		"У вас уже есть открытое обращение, просто напишите сообщение": "You already have an open request, just send a message",
+		"Открыть снова": "Reopen",
+		"Закрыть":       "Close",
+
+		// defaults of content literals
+		"Здравствуйте, {first_name}! Выберите мероприятие, чтобы забронировать место.": "Hello, {first_name}! Choose an event to book a seat.",
+		"Подробнее": "More info",
+		"Подробности скоро появятся.": "Details are coming soon.",
+		"Задать вопрос":               "Ask a question",
+		"Место на {event_title} забронировано! Напишите, на чьё имя оформить бронь.": "Your seat at {event_title} is booked! Send the name for the booking.",
+		"Спасибо, {name}! Осталось оплатить бронь: {amount}.":                        "Thank you, {name}! The only thing left is to pay for the booking: {amount}.",
+		"К сожалению, все места на {event_title} заняты.":                            "Sorry, all seats at {event_title} are taken.",
+		"Бронь на {event_title} ещё не оплачена и скоро истечёт.":                    "Your booking for {event_title} is not paid yet and will expire soon.",
+		"Бронь истекла, место освобождено.":                                          "Your booking has expired, the seat is released.",
+		"К сожалению, мероприятие отменено, бронь аннулирована.":                     "Sorry, the event is cancelled, your booking is void.",
+		"Оплата получена, ждём вас {event_date} в {event_time}!":                     "Payment received, see you on {event_date} at {event_time}!",
+		"Переведите оплату и отправьте фото чека в ответ на это сообщение.":          "Transfer the payment and send a photo of the receipt in reply to this message.",
+		"Чек отправлен на проверку, мы сообщим о результате.":                        "The receipt is sent for review, we will let you know the result.",
+		"Чек не принят, отправьте, пожалуйста, другое фото чека.":                    "The receipt is not accepted, please send another photo of it.",
+		"Бронь подтверждена, оплатить можно на месте.":                               "Your booking is confirmed, you can pay on site.",
+		"Вы в листе ожидания на {event_title}. Мы напишем, если освободится место.":  "You are on the waitlist for {event_title}. We will message you if a seat becomes available.",
+		"Освободилось место на {event_title}!":                                       "A seat at {event_title} is available!",
+		"Предложение места больше не действует.":                                     "The seat offer is no longer valid.",
+		"Запрос на возврат принят, мы свяжемся с вами.":                              "Refund request received, we will contact you.",
+		"К сожалению, в возврате отказано, бронь остаётся в силе.":                   "Sorry, the refund is denied, your booking stays valid.",
+		"Оплата возвращена, бронь отменена.":                                         "The payment is refunded, your booking is cancelled.",
+		"Напишите ваш вопрос одним сообщением.":                                      "Send your question in one message.",
+		"Сообщение отправлено, мы скоро ответим.":                                    "Message sent, we will reply soon.",
+		"Обращение закрыто.":                                                         "The request is closed.",
+		"Обращение открыто снова, напишите сообщение.":                               "The request is reopened, send a message.",
+		"Чтобы задать вопрос, подпишитесь на наш канал.":                             "Subscribe to our channel to ask a question.",
+		"{first_name}, напоминаем о мероприятии, ждём вас!":                          "{first_name}, this is a reminder about the event, we are waiting for you!",
+	},
+}
+
+// tr translates russian text to locale, text is returned as is if there is no translation
+func tr(locale string, text string) string {
+	if translated, ok := translations[locale][text]; ok {
+		return translated
+	}
+	return text
+}
+
+// UserLocale returns locale of user by id, for messages sent not in reply to user
+func (bc BotController) UserLocale(userID int64) string {
+	var user User
+	bc.db.Select("locale").First(&user, "id", userID)
+	if user.Locale == "" {
+		return defaultLocale
+	}
+	return user.Locale
+}
+
+func (bc BotController) SetUserLocale(userID int64, locale string) error {
+	return bc.db.Model(&User{}).Where("id = ?", userID).Update("locale", locale).Error
+}
+
+// localeMiddleware detects locale of users who haven't got one yet
+func localeMiddleware(next Handler) Handler {
+	return func(bc BotController, update tgbotapi.Update, user User) {
+		if from := update.SentFrom(); from != nil && user.Locale == "" {
+			user.Locale = detectLocale(from.LanguageCode)
+			if err := bc.SetUserLocale(user.ID, user.Locale); err != nil {
+				log.Printf("Error setting locale of %d: %s\n", user.ID, err)
+			}
+		}
+		next(bc, update, user)
+	}
+}
+
+func localeKeyboard(callbackPrefix string) tgbotapi.InlineKeyboardMarkup {
+	row := []tgbotapi.InlineKeyboardButton{}
+	for _, locale := range locales {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(localeNames[locale], callbackPrefix+locale))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(row)
+}
+
+func handleLanguageCommand(bc BotController, update tgbotapi.Update, user User) {
+	sendMessageKeyboard(bc, user.ID, tr(user.Locale, "Выберите язык"), localeKeyboard("setlang:"))
+}
+
+func handleSetLanguageCallback(bc BotController, update tgbotapi.Update, user User) {
+	_, locale, _ := strings.Cut(update.CallbackQuery.Data, ":")
+	if !isLocale(locale) {
+		return
+	}
+	if err := bc.SetUserLocale(user.ID, locale); err != nil {
+		log.Printf("Error setting locale of %d: %s\n", user.ID, err)
+		return
+	}
+	sendMessage(bc, user.ID, tr(locale, "Язык изменён"))
+}
@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestDetectLocale(t *testing.T) {
+	cases := map[string]string{
+		"":      "ru",
+		"ru":    "ru",
+		"uk":    "ru",
+		"en":    "en",
+		"en-US": "en",
+		"de":    "en",
+	}
+	for code, want := range cases {
+		if got := detectLocale(code); got != want {
+			t.Errorf("detectLocale(%q) = %s, want %s", code, got, want)
+		}
+	}
+}
+
+func TestBotContentLocaleFallback(t *testing.T) {
+	h := newTestHarness(t)
+	h.bc.SetBotContent("start", "Добро пожаловать", `[{"type":"bold","offset":0,"length":5}]`)
+	h.bc.SetBotContentBy(0, "en", "more_info", "More", "")
+	h.bc.SetBotContent("more_info", "Подробнее", "")
+
+	if got := h.bc.GetBotContentLocale("en", "more_info"); got != "More" {
+		t.Fatalf("en content is %q", got)
+	}
+	if got := h.bc.GetBotContentLocale("ru", "more_info"); got != "Подробнее" {
+		t.Fatalf("ru content is %q", got)
+	}
+	// missing translation falls back to default locale with its entities
+	if got := h.bc.GetBotContentLocale("en", "start"); got != "Добро пожаловать" {
+		t.Fatalf("fallback content is %q", got)
+	}
+	if meta, _ := h.bc.GetBotContentMetadataLocale("en", "start"); meta == "" {
+		t.Fatal("fallback content has no entities")
+	}
+}
+
+func TestUserLocale(t *testing.T) {
+	h := newTestHarness(t)
+	h.bc.SetBotContent("start", "Добро пожаловать", "")
+	h.bc.SetBotContentBy(0, "en", "start", "Welcome", "")
+
+	const userID = 42
+	update := commandMessage(userID, 1, "/start")
+	update.Message.From.LanguageCode = "en"
+	h.process(update)
+	if got := h.last(userID).Params["text"]; got != "Welcome" {
+		t.Fatalf("start text is %q", got)
+	}
+	if button := h.button(h.last(userID), "mybookings"); button == "" {
+		t.Fatal("no bookings button")
+	}
+
+	h.send(userID, "/language")
+	h.press(userID, h.button(h.last(userID), "setlang:ru"))
+	if got := h.bc.UserLocale(userID); got != "ru" {
+		t.Fatalf("locale after switch is %s", got)
+	}
+	// chosen language isn't overridden by language of Telegram client
+	update = commandMessage(userID, 2, "/start")
+	update.Message.From.LanguageCode = "en"
+	h.process(update)
+	if got := h.last(userID).Params["text"]; got != "Добро пожаловать" {
+		t.Fatalf("start text after switch is %q", got)
+	}
+}
+
+func TestPanelLocalizedContent(t *testing.T) {
+	h := newTestHarness(t)
+	const adminID = 7
+	admin := h.bc.GetUser(adminID)
+	h.bc.db.Model(&admin).Update("role_bitmask", 0b11)
+
+	h.press(adminID, "update:start")
+	h.press(adminID, h.button(h.last(adminID), "update:start:en"))
+	h.send(adminID, "Welcome")
+	h.press(adminID, "contentsave")
+	if got := h.bc.GetBotContentLocale("en", "start"); got != "Welcome" {
+		t.Fatalf("en content is %q", got)
+	}
+	if got, err := h.bc.GetBotContentVerbose("start"); err == nil {
+		t.Fatalf("ru content is set to %q", got)
+	}
+
+	// settings have no language variants
+	h.press(adminID, "update:channelid")
+	if user := h.bc.GetUser(adminID); user.State != "contentset" {
+		t.Fatalf("state after choosing setting is %q", user.State)
+	}
+}
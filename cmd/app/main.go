@@ -1,40 +1,127 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+	_ "time/tzdata" // admins may enter any timezone for events
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-var adminCommands = map[string]func(BotController, tgbotapi.Update, User){
-	"/secret":       handleSecretCommand,    // activate admin mode via /secret `AdminPass`
-	"/panel":        handlePanelCommand,     // open bot settings
-	"/usermode":     handleDefaultMessage,   // temporarly disable admin mode to test ui
-	"/deop":         handleDeopCommand,      // removes your admin rights at all!
-	"/id":           handleDefaultMessage,   // to check id of chat
-	"/setchannelid": handleDefaultMessage,   // just type it in channel which one is supposed to be lined with bot
-	"/broadcast":    handleBroadcastCommand, // use /broadcast `msg` to send msg to every known user
-}
+var router = newRouter()
 
-var dubaiLocation, _ = time.LoadLocation("Asia/Dubai")
+func newRouter() *Router {
+	r := NewRouter()
+	r.Use(recoverMiddleware, answerCallbackMiddleware, loadUserMiddleware, localeMiddleware, userInfoMiddleware, logMiddleware)
+
+	r.Message(isSupportReply, func(bc BotController, update tgbotapi.Update, user User) {
+		handleSupportReply(bc, update)
+	})
+	r.Message(isPayment, handleSuccessfulPayment)
+	r.Message(isAlbumItem, handleAlbumItem, requireEffectiveAdmin)
+	r.Fallback(handleDefaultMessage)
+	r.PreCheckout(func(bc BotController, update tgbotapi.Update, user User) {
+		handlePreCheckoutQuery(bc, update)
+	})
+	r.ChannelPost(func(bc BotController, update tgbotapi.Update, user User) {
+		handleChannelPost(bc, update)
+	})
+
+	r.Command("/start", handleStartCommand)
+	r.Command("/secret", handleSecretCommand) // activate admin mode via /secret `AdminPass`
+	r.Command("/mybookings", handleMyBookingsCommand)
+	r.Command("/cancel", handleCancelCommand)
+	r.Command("/language", handleLanguageCommand)
+	r.Command("/panel", handlePanelCommand, requireAdmin)          // open bot settings
+	r.Command("/usermode", handleDefaultMessage, requireAdmin)     // temporarly disable admin mode to test ui
+	r.Command("/deop", handleDeopCommand, requireAdmin)            // removes your admin rights at all!
+	r.Command("/id", handleDefaultMessage, requireAdmin)           // to check id of chat
+	r.Command("/setchannelid", handleDefaultMessage, requireAdmin) // just type it in channel which one is supposed to be lined with bot
+	r.Command("/broadcast", handleBroadcastCommand, requireAdmin)  // compose message and send it to selected segment of users
+
+	// user callbacks
+	r.Callback("more_info", handleMoreInfoCallback)
+	r.CallbackInt("reservedate", handleReserveDateCallback)
+	r.Callback("mybookings", func(bc BotController, update tgbotapi.Update, user User) {
+		handleMyBookings(bc, user)
+	})
+	for _, action := range []string{"cancelres", "cancelresconfirm"} {
+		r.Callback(action, handleCancelReservationCallback)
+	}
+	for _, action := range []string{"refundreq", "refundreqconfirm"} {
+		r.Callback(action, handleRefundRequestCallback)
+	}
+	for _, action := range []string{"usepromo", "skippromo"} {
+		r.Callback(action, handlePromoChoiceCallback)
+	}
+	r.CallbackInt("waitjoin", func(bc BotController, update tgbotapi.Update, user User, id int64) {
+		handleWaitlistJoin(bc, user, id)
+	})
+	r.CallbackInt("waitaccept", func(bc BotController, update tgbotapi.Update, user User, id int64) {
+		handleWaitlistOfferAnswer(bc, user, id, true)
+	})
+	r.CallbackInt("waitdecline", func(bc BotController, update tgbotapi.Update, user User, id int64) {
+		handleWaitlistOfferAnswer(bc, user, id, false)
+	})
+	r.Callback("leaveticket", handleLeaveTicketButton)
+	r.Callback("setlang", handleSetLanguageCallback)
+	// ticket buttons are pressed by author or by staff in support chat, checked by handler
+	for _, action := range []string{"ticketclose", "ticketreopen"} {
+		r.Callback(action, handleTicketCallback)
+	}
+
+	// support chat callbacks, admin rights are checked by handlers
+	for _, action := range []string{"dorefund", "denyrefund", "refunddone"} {
+		r.Callback(action, handleRefundAdminCallback)
+	}
+	for _, action := range []string{"receiptapprove", "receiptreject"} {
+		r.Callback(action, handleReceiptCallback)
+	}
 
-var nearestDates = []time.Time{
-	time.Date(2025, 3, 28, 18, 0, 0, 0, dubaiLocation),
-	time.Date(2025, 4, 1, 18, 0, 0, 0, dubaiLocation),
-	time.Date(2025, 4, 2, 18, 0, 0, 0, dubaiLocation),
+	// admin panel callbacks
+	r.Callback("panel", handlePanelCallback, requireAdmin)
+	r.Callback("contentgroup", handleContentGroupCallback, requireEffectiveAdmin)
+	r.Callback("update", handleUpdateLiteralCallback, requireEffectiveAdmin)
+	for _, action := range []string{"contentpreview", "contentsave", "contentcancel"} {
+		r.Callback(action, handleContentDraftCallback, requireEffectiveAdmin)
+	}
+	r.Callback("contenthistory", handleContentHistoryCallback, requireEffectiveAdmin)
+	r.CallbackInt("contentrev", handleContentRevision, requireEffectiveAdmin)
+	r.CallbackInt("contentrollback", handleContentRollback, requireEffectiveAdmin)
+	for _, action := range []string{
+		"events", "eventnew", "eventdraftsave", "eventdraftcancel", "eventview", "eventwaitlist",
+		"eventreservations", "eventrefundall", "eventrefundallconfirm", "eventpayment", "eventpaymentset",
+		"eventreschedule", "eventcapacity", "eventdetails", "eventreminders", "eventhide",
+		"eventdelete", "eventdeleteconfirm",
+	} {
+		r.Callback(action, handleEventsCallback, requireEffectiveAdmin)
+	}
+	for _, action := range []string{"waitlistup", "waitlistdown", "waitlistremove"} {
+		r.Callback(action, handleWaitlistAdminCallback, requireEffectiveAdmin)
+	}
+	for _, action := range []string{"promos", "promonew", "promoview", "promotoggle", "promosave", "promocancel"} {
+		r.Callback(action, handlePromoAdminCallback, requireEffectiveAdmin)
+	}
+	for _, action := range []string{"broadcastseg", "broadcastevent", "broadcastsend", "broadcastcancel"} {
+		r.Callback(action, handleBroadcastCallback, requireEffectiveAdmin)
+	}
+	return r
 }
 
-const seatscnt = 10
+var dubaiLocation, _ = time.LoadLocation("Asia/Dubai")
 
+// WeekLabels are short names of week days, translated by tr
 var WeekLabels = []string{
 	"ВС",
 	"ПН",
@@ -47,165 +134,80 @@ var WeekLabels = []string{
 
 func main() {
 	var bc = GetBotController()
-	button, callback := getDateButton(nearestDates[0])
-	log.Printf("Buttons: %s, %s\n", button, callback)
 	log.Printf("Location: %s\n", dubaiLocation.String())
-	log.Printf("Diff: %s\n", nearestDates[0].Sub(time.Now()))
 
-	// TODO: REMOVE
-	for _, date := range nearestDates {
-		event := Event{Date: &date}
-		bc.db.Create(&event)
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Run other background tasks
-	go continiousSyncGSheets(bc)
-	go notifyAboutEvents(bc)
-
-	for update := range bc.updates {
-		go ProcessUpdate(bc, update)
+	var background sync.WaitGroup
+	runScheduler(ctx, &background, bc, bc.cfg.TaskWorkers)
+	for _, loop := range []func(context.Context, BotController){expireWaitlistOffers, expireReservations} {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			loop(ctx, bc)
+		}()
 	}
-}
 
-func continiousSyncGSheets(bc BotController) {
-	for true {
-		err := bc.SyncPaidUsersToSheet()
-		if err != nil {
-			log.Printf("Error sync: %s\n", err)
-		}
+	go func() {
+		<-ctx.Done()
+		log.Println("Stopping, waiting for updates in progress...")
+		// updates channel is closed once receiving stops
+		bc.stopUpdates()
+	}()
 
-		time.Sleep(60 * time.Second)
+	dispatcher := NewDispatcher(bc.cfg.UpdateWorkers, bc.cfg.UpdateQueueSize, func(update tgbotapi.Update) {
+		ProcessUpdate(bc, update)
+	})
+	for update := range bc.updates {
+		dispatcher.Dispatch(update)
 	}
-}
-
-func notifyAboutEvents(bc BotController) {
-	// TODO: migrate to tasks system
-	for true {
-		events, _ := bc.GetAllEvents()
-		for _, event := range events {
-			delta := event.Date.Sub(time.Now())
-			if int(math.Ceil(delta.Minutes())) == 8*60 { // 8 hours
-				reservations, _ := bc.GetReservationsByEventID(event.ID)
-				for _, reservation := range reservations {
-					uid := reservation.UserID
-
-					go func() {
-						msg := tgbotapi.NewMessage(uid, bc.GetBotContent("notify_pre_event"))
-						bc.bot.Send(msg)
-					}()
-				}
-			}
-		}
+	dispatcher.Stop()
+	background.Wait()
 
-		time.Sleep(60 * time.Second)
+	if db, err := bc.db.DB(); err == nil {
+		db.Close()
 	}
+	log.Println("Stopped")
 }
 
 func ProcessUpdate(bc BotController, update tgbotapi.Update) {
-	if update.Message != nil {
-		var UserID = update.Message.From.ID
-		user := bc.GetUser(UserID)
-		bc.LogMessage(update)
-		log.Printf("Surname: %s\n", update.SentFrom().LastName)
-		bc.UpdateUserInfo(GetUserInfo(update.SentFrom()))
-
-		// TODO: REMOVE
-		reservation := Reservation{UserID: UserID, EventID: 1, Status: Paid}
-		bc.db.Create(&reservation)
-
-		text := update.Message.Text
-		if strings.HasPrefix(text, "/") {
-			handleCommand(bc, update, user)
-		} else {
-			handleDefaultMessage(bc, update, user)
-		}
-	} else if update.CallbackQuery != nil {
-		handleCallbackQuery(bc, update)
-	} else if update.ChannelPost != nil {
-		handleChannelPost(bc, update)
-	}
+	router.Route(bc, update)
 }
 
-func handleCommand(bc BotController, update tgbotapi.Update, user User) {
-	msg := update.Message
-
-	log.Printf("[%s] %s", update.Message.From.UserName, update.Message.Text)
-	log.Printf("[Entities] %s", update.Message.Entities)
-	log.Printf("[COMMAND] %s", update.Message.Command())
-
-	command := "/" + msg.Command() // if it is not a command, then it will simply be "/"
-	if user.IsAdmin() {
-		f, exists := adminCommands[command] // f is a function that handles specified command
-		if exists {
-			f(bc, update, user)
-			return
-		}
-	}
-
-	// commands for non-admins
-	switch command {
-	case "/start":
-		handleStartCommand(bc, update, user)
-	case "/secret":
-		handleSecretCommand(bc, update, user)
-	}
+func handleMoreInfoCallback(bc BotController, update tgbotapi.Update, user User) {
+	sendBotContent(bc, update.FromChat().ID, user.Locale, "more_info_text", userTemplateVars(bc, user.ID))
 }
 
-func handleCallbackQuery(bc BotController, update tgbotapi.Update) {
-	user := bc.GetUser(update.CallbackQuery.From.ID)
-
-	if update.CallbackQuery.Data == "more_info" {
-		msg := tgbotapi.NewMessage(update.FromChat().ID, bc.GetBotContent("more_info_text"))
-		var entities []tgbotapi.MessageEntity
-		meta, _ := bc.GetBotContentMetadata("more_info_text")
-		json.Unmarshal([]byte(meta), &entities)
-		msg.Entities = entities
-		bc.bot.Send(msg)
-	} else if strings.HasPrefix(update.CallbackQuery.Data, "paidcallback:") {
-		token := strings.Split(update.CallbackQuery.Data, ":")[1]
-		reservationid, err := strconv.ParseInt(token, 10, 64)
-		if err != nil {
-			log.Printf("Error parsing reservation token: %s\n", err)
-			return
-		}
-		reservation, _ := bc.GetReservationByID(reservationid)
-		reservation.Status = Paid
-		bc.UpdateReservation(reservation)
-		notifyPaid(bc, reservation)
-
-		sendMessage(bc, update.CallbackQuery.From.ID, bc.GetBotContent("post_payment_message"))
-	} else if strings.HasPrefix(update.CallbackQuery.Data, "reservedate:") {
-		datetoken := strings.Split(update.CallbackQuery.Data, ":")[1]
-		eventid, err := strconv.ParseInt(datetoken, 10, 64)
-		if err != nil {
-			log.Printf("Error parsing date token: %s\n", err)
-			return
-		}
-		taken, _ := bc.CountReservationsByEventID(eventid)
-		if taken >= seatscnt {
-			sendMessage(bc, user.ID, bc.GetBotContent("soldout_message"))
-			return
-		}
-		// event, _ := bc.GetEvent(eventid)
-		reservation, err := bc.CreateReservation(update.CallbackQuery.From.ID, eventid, "Не указано")
-		if err != nil {
-			log.Printf("Error creating reservation: %s\n", err)
-			return
-		}
-
-		bc.db.Model(&user).Update("state", "enternamereservation:"+strconv.FormatInt(reservation.ID, 10))
-		sendMessage(bc, user.ID, bc.GetBotContent("reserved_message"))
-
-	} else if user.IsEffectiveAdmin() {
-		handleAdminCallback(bc, update, user)
+func handleReserveDateCallback(bc BotController, update tgbotapi.Update, user User, eventid int64) {
+	event, err := bc.GetEvent(eventid)
+	if err != nil || event.Hidden {
+		log.Printf("Error getting event %d: %s\n", eventid, err)
+		return
 	}
-
-	if user.IsAdmin() && update.CallbackQuery.Data == "panel" {
-		handlePanelCallback(bc, update, user)
+	reservation, err := bc.BookSeat(user.ID, eventid, "Не указано")
+	if errors.Is(err, ErrSoldOut) {
+		content := bc.RenderBotContent(user.Locale, "soldout_message", userTemplateVars(bc, user.ID).WithEvent(bc, user.Locale, event))
+		sendContent(bc, user.ID, content, waitlistJoinKeyboard(user.Locale, eventid))
+		return
+	} else if errors.Is(err, ErrAlreadyBooked) {
+		sendMessage(bc, user.ID, tr(user.Locale, "Вы уже забронировали место на это мероприятие"))
+		return
+	} else if err != nil {
+		log.Printf("Error creating reservation: %s\n", err)
+		return
 	}
 
-	canswer := tgbotapi.NewCallback(update.CallbackQuery.ID, "")
-	bc.bot.Send(canswer)
+	startReservationName(bc, user, event, reservation)
+}
+
+// startReservationName asks user to enter name for just created reservation
+func startReservationName(bc BotController, user User, event Event, reservation Reservation) {
+	setState(bc, user, "enternamereservation", StatePayload{ReservationID: reservation.ID})
+	sendMessage(bc, user.ID, eventDetails(user.Locale, event))
+	sendBotContent(bc, user.ID, user.Locale, "reserved_message",
+		userTemplateVars(bc, user.ID).WithReservation(bc, user.Locale, reservation, event))
 }
 
 func handleChannelPost(bc BotController, update tgbotapi.Update) {
@@ -225,23 +227,27 @@ func handleChannelPost(bc BotController, update tgbotapi.Update) {
 
 // Helper functions for specific commands
 func handleStartCommand(bc BotController, update tgbotapi.Update, user User) {
-	bc.db.Model(&user).Update("state", "start")
+	resetState(bc, user)
 	rows := [][]tgbotapi.InlineKeyboardButton{}
 	events, _ := bc.GetAllEvents()
 	for _, event := range events {
-		if event.Date.Sub(time.Now()) < 2*time.Hour {
+		if event.Hidden || event.Date.Sub(time.Now()) < 2*time.Hour {
 			continue
 		}
-		k, _ := getDateButton(*event.Date)
-		taken, _ := bc.CountReservationsByEventID(event.ID)
+		k, _ := getDateButton(user.Locale, event.LocalDate())
+		taken, _ := bc.CountTakenSeats(event.ID)
 		k = strings.Join([]string{
 			k,
-			"(" + strconv.FormatInt(taken, 10) + "/" + strconv.Itoa(seatscnt) + ")",
+			"(" + strconv.FormatInt(taken, 10) + "/" + strconv.FormatInt(event.Capacity, 10) + ")",
 		}, " ")
-		if taken == seatscnt {
-			k += " (Распродано)"
+		if event.Title != "" {
+			k = event.Title + ": " + k
 		}
 		token := "reservedate:" + strconv.FormatInt(event.ID, 10)
+		if event.SeatsLeft(taken) == 0 {
+			k += tr(user.Locale, " (Распродано, лист ожидания)")
+			token = "waitjoin:" + strconv.FormatInt(event.ID, 10)
+		}
 		rows = append(rows,
 			tgbotapi.NewInlineKeyboardRow(
 				tgbotapi.NewInlineKeyboardButtonData(k, token),
@@ -250,35 +256,29 @@ func handleStartCommand(bc BotController, update tgbotapi.Update, user User) {
 	}
 	rows = append(rows,
 		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
-			bc.GetBotContent("more_info"), "more_info",
+			bc.GetBotContentLocale(user.Locale, "more_info"), "more_info",
+		)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			tr(user.Locale, "Мои бронирования"), "mybookings",
+		)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			bc.GetBotContentLocale(user.Locale, "leave_ticket_button"), "leaveticket",
 		)),
 	)
 	kbd := tgbotapi.NewInlineKeyboardMarkup(rows...)
 
-	img, err := bc.GetBotContentVerbose("preview_image")
-	if err != nil || img == "" {
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, bc.GetBotContent("start"))
-		msg.ReplyMarkup = kbd
-		var entities []tgbotapi.MessageEntity
-		meta, _ := bc.GetBotContentMetadata("start")
-		json.Unmarshal([]byte(meta), &entities)
-		msg.Entities = entities
-		bc.bot.Send(msg)
-	} else {
-		msg := tgbotapi.NewPhoto(update.Message.Chat.ID, tgbotapi.FileID(img))
-		msg.Caption = bc.GetBotContent("start")
-		msg.ReplyMarkup = kbd
-		var entities []tgbotapi.MessageEntity
-		meta, _ := bc.GetBotContentMetadata("start")
-		json.Unmarshal([]byte(meta), &entities)
-		msg.CaptionEntities = entities
-		bc.bot.Send(msg)
+	content := bc.RenderBotContent(user.Locale, "start", userTemplateVars(bc, user.ID))
+	if content.Type == ContentText {
+		// start image was set separately before any content could have media
+		preview := bc.RenderBotContent(user.Locale, "preview_image", nil)
+		content.Type, content.Media = preview.Type, preview.Media
 	}
+	sendContent(bc, update.Message.Chat.ID, content, kbd)
 }
 
 func handleSecretCommand(bc BotController, update tgbotapi.Update, user User) {
 	if update.Message.CommandArguments() == bc.cfg.AdminPass || user.IsAdmin() {
-		bc.db.Model(&user).Update("state", "start")
+		user = resetState(bc, user)
 		bc.db.Model(&user).Update("RoleBitmask", user.RoleBitmask|0b11) // set real admin ID (0b1) and effective admin toggle (0b10)
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, "You are admin now!")
 		bc.bot.Send(msg)
@@ -303,167 +303,117 @@ func handleDeopCommand(bc BotController, update tgbotapi.Update, user User) {
 	bc.bot.Send(msg)
 }
 
-func handleBroadcastCommand(bc BotController, update tgbotapi.Update, user User) {
-	if !user.IsAdmin() {
-		return
-	}
-
-	var users []User
-	bc.db.Find(&users)
-
-	for _, user := range users {
-		user = user
-		// TODO!!!
+func handleDefaultMessage(bc BotController, update tgbotapi.Update, user User) {
+	user, handled := handleStateMessage(bc, update, user)
+	if !handled && isTicketFollowUp(bc, update, user) {
+		handleTicketMessage(bc, update, user)
 	}
 }
 
-func handleDefaultMessage(bc BotController, update tgbotapi.Update, user User) {
-	if user.State == "leaveticket" {
-		f := update.Message.From
-		ticket := fmt.Sprintf("User: %s %s\nUsername: %s\nText:\n",
-			f.FirstName, f.LastName,
-			f.UserName)
-		ticket += update.Message.Text
-		chatidstr, err := bc.GetBotContentVerbose("supportchatid")
-		if err != nil {
-			var admins []User
-			bc.db.Where("role_bitmask & 1 = ?", 1).Find(&admins)
-			for _, admin := range admins {
-				msg := tgbotapi.NewMessage(admin.ID, "Support ChatID is not set!!!")
-				msg.Entities = []tgbotapi.MessageEntity{tgbotapi.MessageEntity{
-					Type:   "code",
-					Offset: 1,
-					Length: 2,
-				}}
-				bc.bot.Send(msg)
-			}
-		}
-		chatid, _ := strconv.ParseInt(chatidstr, 10, 64)
-		_, err = bc.bot.Send(tgbotapi.NewMessage(chatid, ticket))
-
-		if err != nil {
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Something went wrong, try again...")
-			bc.bot.Send(msg)
-			return
-		}
-
-		bc.db.Model(&user).Update("state", "start")
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, bc.GetBotContent("sended_notify"))
-		bc.bot.Send(msg)
-	} else if strings.HasPrefix(user.State, "enternamereservation:") {
-		resstr := strings.Split(user.State, ":")[1]
-		reservationid, _ := strconv.ParseInt(resstr, 10, 64)
-		reservation, _ := bc.GetReservationByID(reservationid)
-		reservation.EnteredName = update.Message.Text
-		nd := time.Now().In(dubaiLocation)
-		reservation.TimeBooked = &nd
-		bc.UpdateReservation(reservation)
-
-		sendMessageKeyboard(bc, user.ID, bc.GetBotContent("ask_to_pay"),
-			generateTgInlineKeyboard(map[string]string{"ТЕСТ оплачено": "paidcallback:" + strconv.FormatInt(reservationid, 10)}),
-		)
+func handleReservationNameMessage(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+	reservation, _ := bc.GetReservationByID(payload.ReservationID)
+	if reservation.Status != Booked {
+		resetState(bc, user)
+		sendBotContent(bc, user.ID, user.Locale, "reservation_expired_message", userTemplateVars(bc, user.ID))
+		return
+	}
+	nd := time.Now().In(dubaiLocation)
+	bc.db.Model(&reservation).Updates(Reservation{EnteredName: update.Message.Text, TimeBooked: &nd})
+	reservation.EnteredName = update.Message.Text
 
-	} else if user.IsEffectiveAdmin() {
-		if user.State != "start" {
-			if strings.HasPrefix(user.State, "imgset:") {
-				Literal := strings.Split(user.State, ":")[1]
-				if update.Message.Text == "unset" {
-					var l BotContent
-					bc.db.First(&l, "Literal", Literal)
-					bc.SetBotContent(Literal, "", "")
-				}
-				maxsize := 0
-				fileid := ""
-				for _, p := range update.Message.Photo {
-					if p.FileSize > maxsize {
-						fileid = p.FileID
-						maxsize = p.FileSize
-					}
-				}
-				bc.SetBotContent(Literal, fileid, "")
-				bc.db.Model(&user).Update("state", "start")
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Successfully set new image!")
-				bc.bot.Send(msg)
-			} else if strings.HasPrefix(user.State, "stringset:") {
-				Literal := strings.Split(user.State, ":")[1]
-
-				b, _ := json.Marshal(update.Message.Entities)
-				strEntities := string(b)
-
-				bc.SetBotContent(Literal, update.Message.Text, strEntities)
-				bc.db.Model(&user).Update("state", "start")
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Successfully set new text!")
-				bc.bot.Send(msg)
-			}
-		}
+	if bc.HasActivePromoCodes() {
+		askPromoCode(bc, user, reservation)
+	} else {
+		sendAskToPay(bc, user, reservation)
+		startPayment(bc, user, reservation)
 	}
 }
 
-func handleLeaveTicketButton(bc BotController, update tgbotapi.Update, user User) {
+// isSubscribed checks if user is member of linked channel.
+// If channel is not set or can't be checked admins are notified and user is let through.
+func isSubscribed(bc BotController, userID int64) bool {
 	chatidstr, err := bc.GetBotContentVerbose("channelid")
 	if err != nil {
-		var admins []User
-		bc.db.Where("role_bitmask & 1 = ?", 1).Find(&admins)
-		for _, admin := range admins {
+		for _, admin := range getAdmins(bc) {
 			bc.bot.Send(tgbotapi.NewMessage(admin.ID, "ChannelID is not set!!!"))
 		}
+		return true
 	}
 	chatid, _ := strconv.ParseInt(chatidstr, 10, 64)
 
 	member, err := bc.bot.GetChatMember(tgbotapi.GetChatMemberConfig{
 		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
-			UserID:             update.CallbackQuery.From.ID,
-			SuperGroupUsername: chatidstr,
-			ChatID:             chatid,
+			UserID: userID,
+			ChatID: chatid,
 		},
 	})
 	if err != nil {
-		if strings.Contains(err.Error(), "chat not found") {
-			bc.bot.Send(tgbotapi.NewMessage(user.ID, "No channel ID is set!!!"))
+		log.Printf("Error checking subscription of %d: %s\n", userID, err)
+		for _, admin := range getAdmins(bc) {
+			bc.bot.Send(tgbotapi.NewMessage(admin.ID, "Can't check channel subscription, is bot admin of channel? "+err.Error()))
 		}
+		return true
 	}
-	log.Printf("M: %s, E: %s", member, err)
-	s := member.Status
-	if s == "member" || s == "creator" || s == "admin" {
-		bc.db.Model(&user).Update("state", "leaveticket")
-		bc.bot.Send(tgbotapi.NewMessage(user.ID, bc.GetBotContent("leaveticket_message")))
-	} else {
-		link, err := bc.GetBotContentVerbose("channel_link")
-		msg := tgbotapi.NewMessage(user.ID, bc.GetBotContent("subscribe_message"))
-		if err == nil {
-			kbd := tgbotapi.NewInlineKeyboardMarkup(
-				tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL("Канал", link)),
-			)
-			msg.ReplyMarkup = kbd
+
+	switch member.Status {
+	case "creator", "administrator", "member":
+		return true
+	case "restricted":
+		// restricted users may be not members at all
+		return member.IsMember
+	}
+	return false
+}
+
+func handleLeaveTicketButton(bc BotController, update tgbotapi.Update, user User) {
+	if isSubscribed(bc, user.ID) {
+		if user.State == "leaveticket" {
+			// Enter isn't run for state which is already active, prompt is sent on every press anyway
+			sendBotContent(bc, user.ID, user.Locale, "leaveticket_message", userTemplateVars(bc, user.ID))
 		}
-		if err != nil {
-			log.Printf("NO LINK!!!")
-			var admins []User
-			bc.db.Where("role_bitmask & 1 = ?", 1).Find(&admins)
-			for _, admin := range admins {
-				msg := tgbotapi.NewMessage(admin.ID, "Channel link is not set!!!")
-				msg.Entities = []tgbotapi.MessageEntity{tgbotapi.MessageEntity{
-					Type:   "code",
-					Offset: 1,
-					Length: 2,
-				}}
-				bc.bot.Send(msg)
-			}
+		setState(bc, user, "leaveticket", StatePayload{})
+		return
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	link, err := bc.GetBotContentVerbose("channel_link")
+	if err == nil {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonURL(tr(user.Locale, "Канал"), link)))
+	} else {
+		log.Printf("NO LINK!!!")
+		for _, admin := range getAdmins(bc) {
+			bc.bot.Send(tgbotapi.NewMessage(admin.ID, "Channel link is not set!!!"))
 		}
-		bc.bot.Send(msg)
 	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(tr(user.Locale, "Я подписался, проверить"), "leaveticket"),
+	))
+	sendContent(bc, user.ID, bc.RenderBotContent(user.Locale, "subscribe_message", userTemplateVars(bc, user.ID)), tgbotapi.NewInlineKeyboardMarkup(rows...))
 }
 
-func handleAdminCallback(bc BotController, update tgbotapi.Update, user User) {
-	if strings.HasPrefix(update.CallbackQuery.Data, "update:") {
-		Label := strings.Split(update.CallbackQuery.Data, ":")[1]
-		if Label == "preview_image" {
-			bc.db.Model(&user).Update("state", "imgset:"+Label)
-		} else {
-			bc.db.Model(&user).Update("state", "stringset:"+Label)
-		}
-		bc.bot.Send(tgbotapi.NewMessage(user.ID, "Send me asset (text or picture (NOT as file)).\nSay `unset` to delete image.\nSay /start  to cancel action"))
+// handleUpdateLiteralCallback asks admin for language of literal, then for its new content
+func handleUpdateLiteralCallback(bc BotController, update tgbotapi.Update, user User) {
+	tokens := strings.Split(update.CallbackQuery.Data, ":")
+	if len(tokens) < 2 {
+		return
+	}
+	Label := tokens[1]
+	if _, ok := lookupLiteral(Label); !ok {
+		return
 	}
+	locale := defaultLocale
+	if len(tokens) > 2 && isLocale(tokens[2]) {
+		locale = tokens[2]
+	} else if !isUnlocalizedLiteral(Label) {
+		sendMessageKeyboard(bc, user.ID, "Какой язык изменить?", localeKeyboard("update:"+Label+":"))
+		return
+	}
+
+	if user.State == "contentset" {
+		// admin switches to other literal, its prompt is shown again
+		user = resetState(bc, user)
+	}
+	setState(bc, user, "contentset", StatePayload{Literal: Label, Locale: locale})
 }
 
 func handlePanelCallback(bc BotController, update tgbotapi.Update, user User) {
@@ -499,13 +449,13 @@ func notifyAdminAboutError(bc BotController, errorMessage string) {
 	// 	bc.bot.Send(delcmd)
 	// }
 	// Check if AdminID is set in the config
-	adminID := *bc.cfg.AdminID
-	if adminID == 0 {
+	if bc.cfg.AdminID == nil || *bc.cfg.AdminID == 0 {
 		log.Println("AdminID is not set in the configuration.")
+		return
 	}
 
 	msg := tgbotapi.NewMessage(
-		adminID,
+		*bc.cfg.AdminID,
 		fmt.Sprintf("Error occurred: %s", errorMessage),
 	)
 	bc.bot.Send(msg)
@@ -517,20 +467,20 @@ func getAdmins(bc BotController) []User {
 	return admins
 }
 
-func getDateButton(date time.Time) (string, string) {
+func getDateButton(locale string, date time.Time) (string, string) {
 	// Format the date as needed, e.g., "2006-01-02"
-	wday := WeekLabels[int(date.Local().Weekday())]
+	wday := tr(locale, WeekLabels[int(date.Weekday())])
 	formattedDate := strings.Join([]string{
 		date.Format("02.01.2006"),
 		"(" + wday + ")",
-		"в",
+		tr(locale, "в"),
 		date.Format("15:04"),
 	}, " ")
 
 	// Create a token similar to what GetBotContent accepts
 	token := fmt.Sprintf("reservedate:%s", date.Format("200601021504")) // Example token format
 
-	return strings.Join([]string{"Пойду", formattedDate}, " "), token
+	return strings.Join([]string{tr(locale, "Пойду"), formattedDate}, " "), token
 }
 
 func GetUserInfo(user *tgbotapi.User) UserInfo {
@@ -542,17 +492,39 @@ func GetUserInfo(user *tgbotapi.User) UserInfo {
 	}
 }
 
+func notifySupportChat(bc BotController, msg string) {
+	notifySupportChatKeyboard(bc, msg, nil)
+}
+
+// notifySupportChatKeyboard sends text with buttons to support chat, returns false if it isn't sent
+func notifySupportChatKeyboard(bc BotController, text string, markup interface{}) bool {
+	chatid, err := supportChatID(bc)
+	if err != nil {
+		log.Printf("Support chat id is not set, can't send %q: %s\n", text, err)
+		return false
+	}
+	msg := tgbotapi.NewMessage(chatid, text)
+	if markup != nil {
+		msg.ReplyMarkup = markup
+	}
+	if _, err := bc.bot.Send(msg); err != nil {
+		log.Printf("Error sending to support chat: %s\n", err)
+		return false
+	}
+	return true
+}
+
 func notifyPaid(bc BotController, reservation Reservation) {
-	chatidstr := bc.GetBotContent("supportchatid")
-	chatid, _ := strconv.ParseInt(chatidstr, 10, 64)
 	ui, _ := bc.GetUserInfo(reservation.UserID)
 	event, _ := bc.GetEvent(reservation.EventID)
 	msg := fmt.Sprintf(
-		"Пользователь %s (%s) оплатил на %s",
+		"Пользователь %s (%s) оплатил на %s %s (%s)",
 		ui.FirstName,
 		ui.Username,
-		event.Date.Format("02.01 15:04"),
+		event.Title,
+		formatEventDate(event),
+		formatPrice(event.Price, event.Currency),
 	)
 
-	bc.bot.Send(tgbotapi.NewMessage(chatid, msg))
+	notifySupportChat(bc, msg)
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ManualPaymentProvider asks user for a photo of bank transfer or cash receipt,
+// which is approved or rejected by admins in support chat
+type ManualPaymentProvider struct{}
+
+func (ManualPaymentProvider) Name() string {
+	return "Перевод с чеком"
+}
+
+func (ManualPaymentProvider) StartPayment(bc BotController, user User, reservation Reservation, event Event, price int64) {
+	setState(bc, user, "paymentreceipt", StatePayload{ReservationID: reservation.ID})
+	content := bc.RenderBotContent(user.Locale, "manual_payment_message",
+		userTemplateVars(bc, user.ID).WithReservation(bc, user.Locale, reservation, event))
+	content.Text = fmt.Sprintf(tr(user.Locale, "%s\n\nСумма: %s"), content.Text, tr(user.Locale, formatPrice(price, event.Currency)))
+	sendContent(bc, user.ID, content, nil)
+}
+
+// DoorPaymentProvider keeps reservation booked until user pays at the venue
+type DoorPaymentProvider struct{}
+
+func (DoorPaymentProvider) Name() string {
+	return "Оплата на месте"
+}
+
+func (DoorPaymentProvider) StartPayment(bc BotController, user User, reservation Reservation, event Event, price int64) {
+	// seat must not be released while nobody is expected to pay online
+	bc.db.Model(&reservation).Update("expires_at", nil)
+	resetState(bc, user)
+	content := bc.RenderBotContent(user.Locale, "door_payment_message",
+		userTemplateVars(bc, user.ID).WithReservation(bc, user.Locale, reservation, event))
+	content.Text = fmt.Sprintf(tr(user.Locale, "%s\n\nСумма: %s"), content.Text, tr(user.Locale, formatPrice(price, event.Currency)))
+	sendContent(bc, user.ID, content, nil)
+
+	ui, _ := bc.GetUserInfo(user.ID)
+	notifySupportChat(bc, fmt.Sprintf(
+		"Пользователь %s (%s) оплатит на месте %s %s, имя: %s, сумма: %s",
+		ui.FirstName,
+		ui.Username,
+		event.Title,
+		formatEventDate(event),
+		reservation.EnteredName,
+		formatPrice(price, event.Currency),
+	))
+}
+
+// handlePaymentReceipt forwards receipt photo to support chat for approval
+func handlePaymentReceipt(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+	reservation, err := bc.GetReservationByID(payload.ReservationID)
+	if err != nil || reservation.Status != Booked {
+		resetState(bc, user)
+		sendBotContent(bc, user.ID, user.Locale, "reservation_expired_message", userTemplateVars(bc, user.ID))
+		return
+	}
+	if len(update.Message.Photo) == 0 {
+		sendMessage(bc, user.ID, tr(user.Locale, "Пожалуйста, отправьте фото чека"))
+		return
+	}
+	fileid := largestPhoto(update.Message.Photo)
+
+	chatid, err := strconv.ParseInt(bc.GetBotContent("supportchatid"), 10, 64)
+	if err != nil {
+		log.Printf("Support chat id is not set, can't send receipt: %s\n", err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+	event, _ := bc.GetEvent(reservation.EventID)
+	ui, _ := bc.GetUserInfo(user.ID)
+	id := strconv.FormatInt(reservation.ID, 10)
+	msg := tgbotapi.NewPhoto(chatid, tgbotapi.FileID(fileid))
+	msg.Caption = fmt.Sprintf("Чек по брони #%s\nПользователь %s %s (%s)\nИмя: %s\n%s %s\nСумма: %s",
+		id, ui.FirstName, ui.LastName, ui.Username, reservation.EnteredName,
+		event.Title, formatEventDate(event), formatPrice(bc.ReservationPrice(reservation, event), event.Currency),
+	)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Подтвердить", "receiptapprove:"+id),
+		tgbotapi.NewInlineKeyboardButtonData("Отклонить", "receiptreject:"+id),
+	))
+	if _, err := bc.bot.Send(msg); err != nil {
+		log.Printf("Error sending receipt to support chat: %s\n", err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+
+	// hold seat while admins check the receipt
+	bc.db.Model(&reservation).Updates(map[string]interface{}{"receipt_file_id": fileid, "expires_at": nil})
+	resetState(bc, user)
+	sendBotContent(bc, user.ID, user.Locale, "receipt_sent_message", userTemplateVars(bc, user.ID))
+}
+
+// handleReceiptCallback handles admin's decision on receipt in support chat
+func handleReceiptCallback(bc BotController, update tgbotapi.Update, user User) {
+	if !user.IsAdmin() {
+		return
+	}
+	tokens := strings.Split(update.CallbackQuery.Data, ":")
+	reservationID, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing reservation token: %s\n", err)
+		return
+	}
+	reservation, err := bc.GetReservationByID(reservationID)
+	if err != nil {
+		return
+	}
+
+	var result string
+	if tokens[0] == "receiptapprove" {
+		if confirmPayment(bc, reservation, "", "manual:"+strconv.FormatInt(user.ID, 10)) {
+			result = "Подтверждено"
+		}
+	} else {
+		// seat was held during review, user gets usual hold window to send another receipt
+		renewed, err := bc.RenewReservationHold(reservation.ID)
+		if err != nil {
+			log.Printf("Error renewing hold of reservation %d: %s\n", reservation.ID, err)
+		}
+		if renewed {
+			setState(bc, bc.GetUser(reservation.UserID), "paymentreceipt", StatePayload{ReservationID: reservation.ID})
+			sendBotContent(bc, reservation.UserID, bc.UserLocale(reservation.UserID), "receipt_rejected_message", userTemplateVars(bc, reservation.UserID))
+			result = "Отклонено"
+		}
+	}
+	if result == "" {
+		// reservation was paid, cancelled or processed by other admin meanwhile
+		reservation, _ = bc.GetReservationByID(reservation.ID)
+		result = "Бронь уже " + ReservationStatusString[reservation.Status]
+	}
+
+	// replace buttons with decision so receipt isn't processed twice
+	msg := update.CallbackQuery.Message
+	if msg != nil {
+		edit := tgbotapi.NewEditMessageCaption(msg.Chat.ID, msg.MessageID,
+			fmt.Sprintf("%s\n\n%s (%s)", msg.Caption, result, update.CallbackQuery.From.UserName))
+		bc.bot.Send(edit)
+	}
+}
+
+func largestPhoto(photos []tgbotapi.PhotoSize) string {
+	maxsize := 0
+	fileid := ""
+	for _, p := range photos {
+		if p.FileSize > maxsize {
+			fileid = p.FileID
+			maxsize = p.FileSize
+		}
+	}
+	return fileid
+}
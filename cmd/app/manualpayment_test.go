@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newReceiptTestHarness books seat of manually paid event and sends receipt for it
+func newReceiptTestHarness(t *testing.T) (*testHarness, Reservation) {
+	h := newTestHarness(t)
+	h.bc.cfg.ReservationHold = time.Hour
+	h.bc.SetBotContent("supportchatid", strconv.Itoa(testSupportChatID), "")
+	date := time.Now().Add(72 * time.Hour)
+	event, _ := h.bc.CreateEvent(Event{Date: &date, Capacity: 5, Price: 15000, Currency: "AED", PaymentProvider: "manual"})
+
+	const userID = 42
+	h.press(userID, "reservedate:"+strconv.FormatInt(event.ID, 10))
+	h.send(userID, "Ivan")
+	h.process(mediaMessage(userID, 10, "receipt1", "", ""))
+
+	reservations, _ := h.bc.GetReservationsByEventID(event.ID)
+	if len(reservations) != 1 || reservations[0].ReceiptFileID != "receipt1" || reservations[0].ExpiresAt != nil {
+		t.Fatalf("reservations after receipt: %+v", reservations)
+	}
+	receipt := h.last(testSupportChatID)
+	if receipt.Method != "sendPhoto" || receipt.Params["photo"] != "receipt1" {
+		t.Fatalf("receipt is sent to support chat as %+v", receipt)
+	}
+
+	const adminID = 7
+	admin := h.bc.GetUser(adminID)
+	h.bc.db.Model(&admin).Update("role_bitmask", 0b11)
+	return h, reservations[0]
+}
+
+func TestManualPaymentApprove(t *testing.T) {
+	h, reservation := newReceiptTestHarness(t)
+	h.press(7, "receiptapprove:"+strconv.FormatInt(reservation.ID, 10))
+	reservation, _ = h.bc.GetReservationByID(reservation.ID)
+	if reservation.Status != Paid {
+		t.Fatalf("reservation after approve: %+v", reservation)
+	}
+}
+
+func TestManualPaymentReject(t *testing.T) {
+	h, reservation := newReceiptTestHarness(t)
+	h.press(7, "receiptreject:"+strconv.FormatInt(reservation.ID, 10))
+	reservation, _ = h.bc.GetReservationByID(reservation.ID)
+	if reservation.Status != Booked || reservation.ExpiresAt == nil || reservation.ExpiresAt.Before(time.Now()) {
+		t.Fatalf("reservation after reject: %+v", reservation)
+	}
+	if user := h.bc.GetUser(reservation.UserID); user.State != "paymentreceipt" {
+		t.Fatalf("user is in state %q after reject", user.State)
+	}
+	if caption := h.last(7).Params["caption"]; !strings.Contains(caption, "Отклонено") {
+		t.Fatalf("receipt caption after reject: %q", caption)
+	}
+
+	// user who walks away loses the seat when hold is over
+	h.bc.db.Model(&reservation).Update("expires_at", time.Now().Add(-time.Minute))
+	if expired, _ := h.bc.GetUnpaidReservationsExpiringBefore(time.Now()); len(expired) != 1 {
+		t.Fatalf("%d expired reservations, want 1", len(expired))
+	}
+}
+
+func TestManualPaymentRejectAfterApprove(t *testing.T) {
+	h, reservation := newReceiptTestHarness(t)
+	h.press(7, "receiptapprove:"+strconv.FormatInt(reservation.ID, 10))
+	// other admin rejects the same receipt
+	h.press(7, "receiptreject:"+strconv.FormatInt(reservation.ID, 10))
+	reservation, _ = h.bc.GetReservationByID(reservation.ID)
+	if reservation.Status != Paid {
+		t.Fatalf("reservation after reject of paid: %+v", reservation)
+	}
+	if caption := h.last(7).Params["caption"]; !strings.Contains(caption, "Бронь уже Оплачено") {
+		t.Fatalf("receipt caption after reject of paid: %q", caption)
+	}
+	for _, r := range h.fake.sentTo(reservation.UserID) {
+		if strings.Contains(r.Params["text"], "Чек не принят") {
+			t.Fatal("user is told receipt is rejected after payment is approved")
+		}
+	}
+}
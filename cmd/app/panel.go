@@ -1,23 +1,16 @@
 package main
 
-var assets = map[string]string{
-	"Стартовая картинка":                 "preview_image",
-	"Приветственный текст":               "start",
-	"Кнопка для заявки":                  "leave_ticket_button",
-	"ID чата":                            "supportchatid",
-	"ID канала":                          "channelid",
-	"Уведомление об отправке тикета":     "sended_notify",
-	"Просьба оставить тикет":             "leaveticket_message",
-	"Просьба подписаться на канал":       "subscribe_message",
-	"Ссылка на канал":                    "channel_link",
-	"Подробнее о мероприятии":            "more_info",
-	"Текст о мероприятии":                "more_info_text",
-	"Текст: напоминание за 8 часов":      "notify_pre_event",
-	"Текст: забронированно и ввести имя": "reserved_message",
-	"Текст: после имени на оплату":       "ask_to_pay",
-	"Текст: распродано":                  "soldout_message",
-	"Текст: После оплаты":                "post_payment_message",
-}
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// how many literals are listed on one page of panel section
+const panelPageSize = 8
 
 func handlePanel(bc BotController, user User) {
 	if !user.IsAdmin() {
@@ -27,7 +20,190 @@ func handlePanel(bc BotController, user User) {
 		bc.db.Model(&user).Update("RoleBitmask", user.RoleBitmask|0b10)
 		sendMessage(bc, user.ID, "You was in usermode, turned back to admin mode...")
 	}
-	m := Map(assets, func(v string) string { return "update:" + v })
-	kbd := generateTgInlineKeyboard(m)
-	sendMessageKeyboard(bc, user.ID, "Выберите пункт для изменения", kbd)
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for i, group := range contentGroups() {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(group, "contentgroup:"+strconv.Itoa(i)+":0"),
+		))
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Мероприятия", "events")),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Промокоды", "promos")),
+	)
+	sendMessageKeyboard(bc, user.ID, "Выберите раздел", tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// handleContentGroupCallback lists page of literals of panel section, data is contentgroup:<section>:<page>
+func handleContentGroupCallback(bc BotController, update tgbotapi.Update, user User) {
+	tokens := strings.Split(update.CallbackQuery.Data, ":")
+	if len(tokens) < 3 {
+		return
+	}
+	groups := contentGroups()
+	index, err := strconv.Atoi(tokens[1])
+	if err != nil || index < 0 || index >= len(groups) {
+		return
+	}
+	page, _ := strconv.Atoi(tokens[2])
+	literals := groupLiterals(bc, groups[index])
+	pages := (len(literals) + panelPageSize - 1) / panelPageSize
+	page = max(0, min(page, pages-1))
+
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, l := range literals[page*panelPageSize : min(len(literals), (page+1)*panelPageSize)] {
+		label := l.Description
+		if !bc.IsBotContentSet(l.Key) {
+			label += " (не задано)"
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, "update:"+l.Key)))
+	}
+	nav := []tgbotapi.InlineKeyboardButton{}
+	if page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("◀", fmt.Sprintf("contentgroup:%d:%d", index, page-1)))
+	}
+	if page < pages-1 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("▶", fmt.Sprintf("contentgroup:%d:%d", index, page+1)))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Назад", "panel")))
+
+	text := groups[index]
+	if pages > 1 {
+		text += fmt.Sprintf(", страница %d из %d", page+1, pages)
+	}
+	sendMessageKeyboard(bc, user.ID, text, tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func askAsset(bc BotController, user User, payload StatePayload) {
+	literal, _ := lookupLiteral(payload.Literal)
+	locale := payload.ContentLocale()
+	text := literal.Description + " (" + payload.Literal + ")\n"
+	if !isUnlocalizedLiteral(payload.Literal) {
+		text += "Language: " + localeNames[locale] + "\n"
+	}
+	if isTextOnlyLiteral(payload.Literal) {
+		text += "Send me text."
+	} else {
+		text += "Send me content: text, photo, video, animation, document or album. Caption of media is used as its text."
+	}
+	text += "\nYou will see preview before it's saved."
+	text += "\nSay `unset` to clear content, users will get default.\nSay /cancel to cancel action"
+	if !isTextOnlyLiteral(payload.Literal) {
+		text += "\n\n" + templateHelp()
+	}
+	sendMessageKeyboard(bc, user.ID, text,
+		tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("История изменений", "contenthistory:"+payload.Literal+":"+locale),
+		)),
+	)
+	sendCurrentContent(bc, user, payload.Literal, locale)
+}
+
+// sendCurrentContent shows admin what users of locale get for literal now
+func sendCurrentContent(bc BotController, user User, literal string, locale string) {
+	c, err := bc.getBotContentLocale(locale, literal)
+	switch {
+	case err != nil:
+		if def := literalDefault(locale, literal); def != "" {
+			sendMessage(bc, user.ID, "Сейчас не задано, пользователи получают текст по умолчанию:\n\n"+def)
+		} else {
+			sendMessage(bc, user.ID, "Сейчас не задано")
+		}
+		return
+	case c.Locale != locale:
+		sendMessage(bc, user.ID, "Сейчас не задано, пользователи получают вариант на языке "+localeNames[c.Locale]+":")
+	default:
+		sendMessage(bc, user.ID, "Текущее значение:")
+	}
+	if err := sendContent(bc, user.ID, renderContent(c, nil), nil); err != nil {
+		sendMessage(bc, user.ID, "Не удалось показать значение: "+err.Error())
+	}
+}
+
+func contentDraftKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Предпросмотр", "contentpreview")),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Сохранить", "contentsave"),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", "contentcancel"),
+		),
+	)
+}
+
+// sendContentDraftPreview shows draft as users would see it, placeholders are filled with examples
+func sendContentDraftPreview(bc BotController, user User, draft ContentDraft) {
+	preview := renderContent(draft.BotContent(), sampleTemplateVars())
+	if preview.Type == ContentText && preview.Text == "" {
+		sendMessage(bc, user.ID, "Значение будет очищено, пользователи получат текст по умолчанию")
+	} else if err := sendContent(bc, user.ID, preview, nil); err != nil {
+		sendMessage(bc, user.ID, "Не удалось показать предпросмотр: "+err.Error())
+	}
+	sendMessageKeyboard(bc, user.ID, "Сохранить? Можно отправить другой вариант.", contentDraftKeyboard())
+}
+
+// handleContentSetMessage keeps admin's message as draft of literal, its type is chosen by what is sent
+func handleContentSetMessage(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+	content, ok := contentFromMessage(update.Message)
+	if update.Message.Text == "unset" {
+		content, ok = BotContent{Type: ContentText}, true
+	}
+	if !ok || (isTextOnlyLiteral(payload.Literal) && content.Type != ContentText) {
+		sendMessage(bc, user.ID, "This content type is not supported here, try again")
+		return
+	}
+	draft := ContentDraft{
+		UserID:   user.ID,
+		Literal:  payload.Literal,
+		Locale:   payload.ContentLocale(),
+		Type:     content.Type,
+		Media:    content.Media,
+		Content:  content.Content,
+		Metadata: content.Metadata,
+	}
+	if err := bc.SaveContentDraft(draft); err != nil {
+		log.Printf("Error saving draft of %s: %s\n", payload.Literal, err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+	if draft.Type == ContentAlbum {
+		// other files of album come in next messages
+		sendMessageKeyboard(bc, user.ID, "Альбом получен. Когда загрузятся все файлы, нажмите «Предпросмотр» или «Сохранить»", contentDraftKeyboard())
+		return
+	}
+	sendContentDraftPreview(bc, user, draft)
+}
+
+func handleContentDraftCallback(bc BotController, update tgbotapi.Update, user User) {
+	if update.CallbackQuery.Data == "contentcancel" {
+		bc.DeleteContentDraft(user.ID)
+		resetState(bc, user)
+		sendMessage(bc, user.ID, "Отменено")
+		return
+	}
+	draft, err := bc.GetContentDraft(user.ID)
+	if err != nil {
+		sendMessage(bc, user.ID, "Черновик не найден, начните заново")
+		return
+	}
+	switch update.CallbackQuery.Data {
+	case "contentpreview":
+		sendContentDraftPreview(bc, user, draft)
+	case "contentsave":
+		if err := bc.SaveBotContent(user.ID, draft.BotContent()); err != nil {
+			log.Printf("Error setting content %s: %s\n", draft.Literal, err)
+			sendMessage(bc, user.ID, "Something went wrong, try again...")
+			return
+		}
+		bc.DeleteContentDraft(user.ID)
+		if user.State == "contentset" {
+			resetState(bc, user)
+		}
+		if draft.Type == ContentAlbum {
+			sendMessage(bc, user.ID, "Successfully set new album!")
+		} else {
+			sendMessage(bc, user.ID, "Successfully set new content!")
+		}
+	}
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// how long hold of reservation is extended when user starts checkout,
+// so it doesn't expire while payment is processed
+const checkoutHoldExtension = 10 * time.Minute
+
+func invoicePayload(reservation Reservation) string {
+	return "reservation:" + strconv.FormatInt(reservation.ID, 10)
+}
+
+func parseInvoicePayload(payload string) (int64, error) {
+	token, found := strings.CutPrefix(payload, "reservation:")
+	if !found {
+		return 0, fmt.Errorf("unknown invoice payload: %s", payload)
+	}
+	return strconv.ParseInt(token, 10, 64)
+}
+
+// PaymentProvider is a way user pays for reservation, chosen per event
+type PaymentProvider interface {
+	// Name is shown to admins when choosing payment method of event
+	Name() string
+	// StartPayment asks user to pay price for booked reservation
+	StartPayment(bc BotController, user User, reservation Reservation, event Event, price int64)
+}
+
+var paymentProviders = map[string]PaymentProvider{
+	"telegram": TelegramPaymentProvider{},
+	"manual":   ManualPaymentProvider{},
+	"door":     DoorPaymentProvider{},
+}
+
+// order of payment methods in admin panel
+var paymentProviderKeys = []string{"telegram", "manual", "door"}
+
+func getPaymentProvider(event Event) PaymentProvider {
+	provider, ok := paymentProviders[event.PaymentProvider]
+	if !ok {
+		return paymentProviders["telegram"]
+	}
+	return provider
+}
+
+// startPayment starts payment with provider of reservation's event.
+// Free events are marked paid right away.
+func startPayment(bc BotController, user User, reservation Reservation) {
+	event, err := bc.GetEvent(reservation.EventID)
+	if err != nil {
+		log.Printf("Error getting event %d: %s\n", reservation.EventID, err)
+		return
+	}
+
+	price := bc.ReservationPrice(reservation, event)
+	if price == 0 {
+		confirmPayment(bc, reservation, "", "")
+		return
+	}
+	bc.db.Model(&reservation).Update("payment_provider", event.PaymentProvider)
+	getPaymentProvider(event).StartPayment(bc, user, reservation, event, price)
+}
+
+// TelegramPaymentProvider sends Telegram invoice, payment is confirmed by SuccessfulPayment message
+type TelegramPaymentProvider struct{}
+
+func (TelegramPaymentProvider) Name() string {
+	return "Telegram (карта или звёзды)"
+}
+
+func (TelegramPaymentProvider) StartPayment(bc BotController, user User, reservation Reservation, event Event, price int64) {
+	title := event.Title
+	if title == "" {
+		title = tr(user.Locale, "Бронирование")
+	}
+	description := fmt.Sprintf(tr(user.Locale, "%s, имя: %s"), formatEventDateLocale(user.Locale, event), reservation.EnteredName)
+	providerToken := bc.cfg.PaymentProviderToken
+	if event.Currency == "XTR" {
+		// Telegram requires empty provider token for invoices in stars
+		providerToken = ""
+	}
+	invoice := tgbotapi.NewInvoice(
+		user.ID,
+		title,
+		description,
+		invoicePayload(reservation),
+		providerToken,
+		"",
+		event.Currency,
+		[]tgbotapi.LabeledPrice{{Label: title, Amount: int(price)}},
+	)
+	// without it library sends `null` which Bot API rejects
+	invoice.SuggestedTipAmounts = []int{}
+	if _, err := bc.bot.Send(invoice); err != nil {
+		log.Printf("Error sending invoice for reservation %d: %s\n", reservation.ID, err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+	}
+}
+
+// checkCheckout verifies reservation from invoice payload is still payable
+func checkCheckout(bc BotController, userID int64, payload string, currency string, amount int) (Reservation, error) {
+	reservationID, err := parseInvoicePayload(payload)
+	if err != nil {
+		return Reservation{}, err
+	}
+	reservation, err := bc.GetReservationByID(reservationID)
+	if err != nil || reservation.UserID != userID {
+		return Reservation{}, fmt.Errorf("reservation %d not found", reservationID)
+	}
+	if reservation.Status != Booked {
+		return reservation, fmt.Errorf("reservation %d is %s", reservationID, ReservationStatusString[reservation.Status])
+	}
+	event, err := bc.GetEvent(reservation.EventID)
+	if err != nil || event.Date.Before(time.Now()) {
+		return reservation, fmt.Errorf("event %d is not available", reservation.EventID)
+	}
+	if event.Currency != currency || int(bc.ReservationPrice(reservation, event)) != amount {
+		return reservation, fmt.Errorf("price of event %d has changed", reservation.EventID)
+	}
+	return reservation, nil
+}
+
+// sendAskToPay sends ask_to_pay content before payment of reservation is started
+func sendAskToPay(bc BotController, user User, reservation Reservation) {
+	event, _ := bc.GetEvent(reservation.EventID)
+	sendBotContent(bc, user.ID, user.Locale, "ask_to_pay",
+		userTemplateVars(bc, user.ID).WithReservation(bc, user.Locale, reservation, event))
+}
+
+func handlePreCheckoutQuery(bc BotController, update tgbotapi.Update) {
+	query := update.PreCheckoutQuery
+	answer := tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: query.ID, OK: true}
+
+	reservation, err := checkCheckout(bc, query.From.ID, query.InvoicePayload, query.Currency, query.TotalAmount)
+	if err != nil {
+		log.Printf("Rejecting checkout: %s\n", err)
+		answer.OK = false
+		answer.ErrorMessage = bc.GetBotContentLocale(bc.UserLocale(query.From.ID), "reservation_expired_message")
+	} else if reservation.ExpiresAt != nil && time.Until(*reservation.ExpiresAt) < checkoutHoldExtension {
+		bc.db.Model(&reservation).Update("expires_at", time.Now().Add(checkoutHoldExtension))
+	}
+
+	if _, err := bc.bot.Request(answer); err != nil {
+		log.Printf("Error answering pre-checkout query: %s\n", err)
+	}
+}
+
+func handleSuccessfulPayment(bc BotController, update tgbotapi.Update, user User) {
+	payment := update.Message.SuccessfulPayment
+	reservationID, err := parseInvoicePayload(payment.InvoicePayload)
+	if err != nil {
+		log.Printf("Error parsing payment payload: %s\n", err)
+		return
+	}
+	reservation, err := bc.GetReservationByID(reservationID)
+	if err != nil {
+		log.Printf("Error getting paid reservation %d: %s\n", reservationID, err)
+		return
+	}
+	if reservation.Status == Paid && reservation.TelegramChargeID == payment.TelegramPaymentChargeID {
+		// the same payment is delivered again, e.g. after restart
+		log.Printf("Skipping repeated payment of reservation %d\n", reservation.ID)
+		return
+	}
+
+	if !confirmPayment(bc, reservation, payment.TelegramPaymentChargeID, payment.ProviderPaymentChargeID) {
+		// money is taken but seat is gone, has to be resolved by support
+		notifySupportChat(bc, fmt.Sprintf(
+			"Оплата по брони #%d (%s) получена, но бронь уже недействительна. Требуется возврат. Telegram charge: %s, provider charge: %s",
+			reservation.ID, ReservationStatusString[reservation.Status],
+			payment.TelegramPaymentChargeID, payment.ProviderPaymentChargeID,
+		))
+		sendMessage(bc, user.ID, tr(user.Locale, "Оплата получена, но бронь уже недействительна. Мы свяжемся с вами для возврата средств"))
+	}
+}
+
+// confirmPayment marks reservation paid and notifies user and support chat
+func confirmPayment(bc BotController, reservation Reservation, telegramChargeID string, providerChargeID string) bool {
+	paid, err := bc.MarkReservationPaid(reservation.ID, telegramChargeID, providerChargeID)
+	if err != nil {
+		log.Printf("Error marking reservation %d paid: %s\n", reservation.ID, err)
+	}
+	if !paid {
+		return false
+	}
+	reservation.Status = Paid
+	notifyPaid(bc, reservation)
+
+	user := bc.GetUser(reservation.UserID)
+	if user.InReservationState("enternamereservation", reservation.ID) {
+		resetState(bc, user)
+	}
+	event, _ := bc.GetEvent(reservation.EventID)
+	sendBotContent(bc, reservation.UserID, user.Locale, "post_payment_message",
+		userTemplateVars(bc, user.ID).WithReservation(bc, user.Locale, reservation, event))
+	return true
+}
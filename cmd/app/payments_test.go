@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestTelegramCheckout(t *testing.T) {
+	bc := newTestBotController(t)
+	bot, fake := newFakeBotAPI(t)
+	bc.bot = bot
+	bc.cfg.PaymentProviderToken = "provider-token"
+
+	event := createTestEvent(t, bc, 5)
+	event.Price = 15000
+	event.Currency = "AED"
+	bc.UpdateEvent(event)
+	reservation, err := bc.BookSeat(42, event.ID, "name")
+	if err != nil {
+		t.Fatalf("book seat: %s", err)
+	}
+	user := bc.GetUser(42)
+
+	startPayment(bc, user, reservation)
+	invoices := fake.find("sendInvoice")
+	if len(invoices) != 1 || invoices[0].Params["payload"] != invoicePayload(reservation) || invoices[0].Params["provider_token"] != "provider-token" {
+		t.Fatalf("invoices = %v, want one for reservation", invoices)
+	}
+
+	query := func(amount int) tgbotapi.Update {
+		return tgbotapi.Update{PreCheckoutQuery: &tgbotapi.PreCheckoutQuery{
+			ID:             "q",
+			From:           &tgbotapi.User{ID: 42},
+			Currency:       "AED",
+			TotalAmount:    amount,
+			InvoicePayload: invoicePayload(reservation),
+		}}
+	}
+	handlePreCheckoutQuery(bc, query(100))
+	handlePreCheckoutQuery(bc, query(15000))
+	answers := fake.find("answerPreCheckoutQuery")
+	if len(answers) != 2 || answers[0].Params["ok"] == "true" || answers[1].Params["ok"] != "true" {
+		t.Fatalf("pre-checkout answers = %v, want rejected then accepted", answers)
+	}
+
+	successfulPayment := tgbotapi.Update{Message: &tgbotapi.Message{
+		From: &tgbotapi.User{ID: 42},
+		SuccessfulPayment: &tgbotapi.SuccessfulPayment{
+			Currency:                "AED",
+			TotalAmount:             15000,
+			InvoicePayload:          invoicePayload(reservation),
+			TelegramPaymentChargeID: "tg-charge",
+			ProviderPaymentChargeID: "provider-charge",
+		},
+	}}
+	handleSuccessfulPayment(bc, successfulPayment, user)
+	paid, _ := bc.GetReservationByID(reservation.ID)
+	if paid.Status != Paid || paid.TelegramChargeID != "tg-charge" || paid.ProviderChargeID != "provider-charge" {
+		t.Errorf("reservation after payment = %+v", paid)
+	}
+
+	// payment delivered again is ignored
+	sent := len(fake.sentTo(42))
+	handleSuccessfulPayment(bc, successfulPayment, user)
+	if got := fake.sentTo(42); len(got) != sent {
+		t.Errorf("repeated payment sent %+v", got[sent:])
+	}
+}
+
+func TestStarsInvoice(t *testing.T) {
+	bc := newTestBotController(t)
+	bot, fake := newFakeBotAPI(t)
+	bc.bot = bot
+	bc.cfg.PaymentProviderToken = "provider-token"
+
+	event := createTestEvent(t, bc, 5)
+	event.Price = 100
+	event.Currency = "XTR"
+	bc.UpdateEvent(event)
+	reservation, _ := bc.BookSeat(42, event.ID, "name")
+
+	startPayment(bc, bc.GetUser(42), reservation)
+	invoices := fake.find("sendInvoice")
+	if len(invoices) != 1 || invoices[0].Params["currency"] != "XTR" || invoices[0].Params["provider_token"] != "" {
+		t.Fatalf("invoices = %v, want one in stars without provider token", invoices)
+	}
+}
+
+func TestRefundStarPayment(t *testing.T) {
+	bc := newTestBotController(t)
+	bot, fake := newFakeBotAPI(t)
+	bc.bot = bot
+
+	event := createTestEvent(t, bc, 1)
+	event.Price = 100
+	event.Currency = "XTR"
+	bc.UpdateEvent(event)
+	reservation, _ := bc.BookSeat(42, event.ID, "name")
+	bc.MarkReservationPaid(reservation.ID, "tg-charge", "")
+	reservation, _ = bc.GetReservationByID(reservation.ID)
+
+	if _, err := refundReservation(bc, reservation); err != nil {
+		t.Fatalf("refund reservation: %s", err)
+	}
+	refunds := fake.find("refundStarPayment")
+	if len(refunds) != 1 || refunds[0].Params["telegram_payment_charge_id"] != "tg-charge" || refunds[0].Params["user_id"] != "42" {
+		t.Fatalf("refundStarPayment requests = %v", refunds)
+	}
+	refunded, _ := bc.GetReservationByID(reservation.ID)
+	if refunded.Status != Refunded {
+		t.Errorf("reservation status = %s, want %s", ReservationStatusString[refunded.Status], ReservationStatusString[Refunded])
+	}
+	if _, err := refundReservation(bc, refunded); err == nil {
+		t.Errorf("refunded reservation twice")
+	}
+	if _, err := bc.BookSeat(43, event.ID, "name"); err != nil {
+		t.Errorf("book seat released by refund: %s", err)
+	}
+}
+
+func TestRefundRepeatedTap(t *testing.T) {
+	bc := newTestBotController(t)
+	bot, fake := newFakeBotAPI(t)
+	bc.bot = bot
+
+	event := createTestEvent(t, bc, 1)
+	event.Price = 100
+	event.Currency = "XTR"
+	bc.UpdateEvent(event)
+	reservation, _ := bc.BookSeat(42, event.ID, "name")
+	bc.MarkReservationPaid(reservation.ID, "tg-charge", "")
+	reservation, _ = bc.GetReservationByID(reservation.ID)
+
+	// both taps read reservation before it was refunded
+	if _, err := refundReservation(bc, reservation); err != nil {
+		t.Fatalf("refund reservation: %s", err)
+	}
+	if _, err := refundReservation(bc, reservation); err == nil {
+		t.Errorf("refunded reservation twice")
+	}
+	if refunds := fake.find("refundStarPayment"); len(refunds) != 1 {
+		t.Fatalf("%d refundStarPayment requests, want 1", len(refunds))
+	}
+}
+
+func TestRefundEventClosesWaitlist(t *testing.T) {
+	bc := newTestBotController(t)
+	bot, _ := newFakeBotAPI(t)
+	bc.bot = bot
+
+	event := createTestEvent(t, bc, 1)
+	reservation, _ := bc.BookSeat(42, event.ID, "name")
+	bc.MarkReservationPaid(reservation.ID, "", "")
+	bc.JoinWaitlist(43, event.ID)
+
+	refundEvent(bc, bc.GetUser(7), event)
+	if waitlist, _ := bc.GetWaitlist(event.ID); len(waitlist) != 0 {
+		t.Fatalf("waitlist of cancelled event: %+v", waitlist)
+	}
+	if _, err := bc.BookSeat(44, event.ID, "name"); !errors.Is(err, ErrEventHidden) {
+		t.Fatalf("booking of cancelled event: %v, want ErrEventHidden", err)
+	}
+}
+
+func TestManualRefund(t *testing.T) {
+	h := newTestHarness(t)
+	h.bc.SetBotContent("supportchatid", strconv.Itoa(testSupportChatID), "")
+	const adminID = 7
+	admin := h.bc.GetUser(adminID)
+	h.bc.db.Model(&admin).Update("role_bitmask", 0b11)
+
+	event := createTestEvent(t, h.bc, 1)
+	reservation, _ := h.bc.BookSeat(42, event.ID, "name")
+	h.bc.MarkReservationPaid(reservation.ID, "tg-charge", "provider-charge")
+	reservation, _ = h.bc.GetReservationByID(reservation.ID)
+
+	// card payment is returned by support, reservation waits for confirmation
+	if refunded, err := refundReservation(h.bc, reservation); refunded || err != nil {
+		t.Fatalf("manual refund = %v, %v", refunded, err)
+	}
+	if r, _ := h.bc.GetReservationByID(reservation.ID); r.Status != Refunding {
+		t.Fatalf("reservation status = %s, want %s", ReservationStatusString[r.Status], ReservationStatusString[Refunding])
+	}
+	for _, r := range h.fake.sentTo(42) {
+		if strings.Contains(r.Params["text"], "Оплата возвращена") {
+			t.Fatal("user is told money is returned before support returned it")
+		}
+	}
+
+	done := h.button(h.last(testSupportChatID), "refunddone")
+	h.press(adminID, done)
+	if r, _ := h.bc.GetReservationByID(reservation.ID); r.Status != Refunded {
+		t.Fatalf("reservation status after confirmation = %s", ReservationStatusString[r.Status])
+	}
+	if got := h.last(42).Params["text"]; got != "Оплата возвращена, бронь отменена." {
+		t.Fatalf("user got %q", got)
+	}
+	// repeated confirmation changes nothing
+	h.press(adminID, done)
+	if got := h.last(adminID).Params["text"]; !strings.Contains(got, "Бронь уже") {
+		t.Fatalf("repeated confirmation: %q", got)
+	}
+}
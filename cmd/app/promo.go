@@ -0,0 +1,351 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var promoDraftSteps = []string{"code", "discount", "limit", "userlimit", "validity", "event"}
+
+var promoDraftPrompts = map[string]string{
+	"code":      "Введите промокод (латиница и цифры)",
+	"discount":  "Введите скидку: 10% для процентной или 50 (AED), 100 XTR для фиксированной суммы в валюте мероприятия",
+	"limit":     "Введите общее количество использований (0 - без ограничений)",
+	"userlimit": "Введите количество использований одним пользователем (0 - без ограничений)",
+	"validity":  "Введите период действия в формате ДД.ММ.ГГГГ-ДД.ММ.ГГГГ (или - без ограничений)",
+	"event":     "Введите номер мероприятия, для которого действует код (или - для всех)",
+}
+
+// askPromoCode offers user to enter promo code before payment
+func askPromoCode(bc BotController, user User, reservation Reservation) {
+	event, _ := bc.GetEvent(reservation.EventID)
+	id := strconv.FormatInt(reservation.ID, 10)
+	text := fmt.Sprintf(tr(user.Locale, "Сумма к оплате: %s\nЕсть промокод?"), tr(user.Locale, formatPrice(bc.ReservationPrice(reservation, event), event.Currency)))
+	sendMessageKeyboard(bc, user.ID, text, tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(tr(user.Locale, "Ввести промокод"), "usepromo:"+id),
+		tgbotapi.NewInlineKeyboardButtonData(tr(user.Locale, "Перейти к оплате"), "skippromo:"+id),
+	)))
+}
+
+func handlePromoChoiceCallback(bc BotController, update tgbotapi.Update, user User) {
+	tokens := strings.Split(update.CallbackQuery.Data, ":")
+	reservationID, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing reservation token: %s\n", err)
+		return
+	}
+	reservation, err := bc.GetReservationByID(reservationID)
+	if err != nil || reservation.UserID != user.ID {
+		return
+	}
+	if reservation.Status != Booked {
+		sendBotContent(bc, user.ID, user.Locale, "reservation_expired_message", userTemplateVars(bc, user.ID))
+		return
+	}
+
+	if tokens[0] == "usepromo" {
+		setState(bc, user, "enterpromo", StatePayload{ReservationID: reservationID})
+		sendMessage(bc, user.ID, tr(user.Locale, "Введите промокод"))
+		return
+	}
+	sendAskToPay(bc, user, reservation)
+	startPayment(bc, user, reservation)
+}
+
+func handlePromoCodeMessage(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+	reservation, err := bc.GetReservationByID(payload.ReservationID)
+	if err != nil || reservation.Status != Booked {
+		resetState(bc, user)
+		sendBotContent(bc, user.ID, user.Locale, "reservation_expired_message", userTemplateVars(bc, user.ID))
+		return
+	}
+
+	promo, err := validatePromoCode(bc, strings.TrimSpace(update.Message.Text), reservation)
+	if err == nil {
+		err = bc.ApplyPromoCode(reservation, promo)
+		if errors.Is(err, ErrPromoLimitReached) {
+			err = errors.New("Промокод больше недоступен")
+		}
+	}
+	if err != nil {
+		sendMessage(bc, user.ID, tr(user.Locale, err.Error()))
+		askPromoCode(bc, user, reservation)
+		return
+	}
+
+	reservation.PromoCodeID = promo.ID
+	event, _ := bc.GetEvent(reservation.EventID)
+	user = setState(bc, user, "enternamereservation", payload)
+	sendMessage(bc, user.ID, tr(user.Locale, "Промокод применён, сумма к оплате: ")+
+		tr(user.Locale, formatPrice(bc.ReservationPrice(reservation, event), event.Currency)))
+	sendAskToPay(bc, user, reservation)
+	startPayment(bc, user, reservation)
+}
+
+// validatePromoCode checks code may be used for reservation, limits are checked on apply
+func validatePromoCode(bc BotController, code string, reservation Reservation) (PromoCode, error) {
+	promo, err := bc.GetPromoCodeByCode(code)
+	if err != nil || promo.Disabled {
+		return promo, errors.New("Промокод не найден")
+	}
+	now := time.Now()
+	if (promo.ValidFrom != nil && now.Before(*promo.ValidFrom)) || (promo.ValidUntil != nil && now.After(*promo.ValidUntil)) {
+		return promo, errors.New("Срок действия промокода истёк или ещё не начался")
+	}
+	event, _ := bc.GetEvent(reservation.EventID)
+	if (promo.EventID != 0 && promo.EventID != reservation.EventID) || !promo.AppliesTo(event) {
+		return promo, errors.New("Промокод не действует для этого мероприятия")
+	}
+	return promo, nil
+}
+
+func formatPromoCode(promo PromoCode) string {
+	var discount string
+	if promo.DiscountType == PercentDiscount {
+		discount = strconv.FormatInt(promo.Discount, 10) + "%"
+	} else {
+		discount = formatPrice(promo.Discount, promo.Currency)
+	}
+	lines := []string{promo.Code, "Скидка: " + discount}
+	if promo.UsageLimit != 0 {
+		lines = append(lines, "Использований: "+strconv.FormatInt(promo.UsageLimit, 10))
+	}
+	if promo.PerUserLimit != 0 {
+		lines = append(lines, "На пользователя: "+strconv.FormatInt(promo.PerUserLimit, 10))
+	}
+	if promo.ValidFrom != nil && promo.ValidUntil != nil {
+		lines = append(lines, "Действует: "+promo.ValidFrom.In(dubaiLocation).Format("02.01.2006")+
+			"-"+promo.ValidUntil.In(dubaiLocation).Format("02.01.2006"))
+	}
+	if promo.EventID != 0 {
+		lines = append(lines, "Мероприятие: #"+strconv.FormatInt(promo.EventID, 10))
+	}
+	if promo.Disabled {
+		lines = append(lines, "Отключён")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func handlePromoPanel(bc BotController, user User) {
+	promos, _ := bc.GetAllPromoCodes()
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, promo := range promos {
+		label := promo.Code
+		if promo.Disabled {
+			label += " (отключён)"
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, "promoview:"+strconv.FormatInt(promo.ID, 10)),
+		))
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Создать промокод", "promonew")),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Назад", "panel")),
+	)
+	sendMessageKeyboard(bc, user.ID, "Промокоды", tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func handlePromoView(bc BotController, user User, promo PromoCode) {
+	var used int64
+	bc.db.Model(&Reservation{}).Where("promo_code_id = ? AND status IN ?", promo.ID, seatHoldingStatuses).Count(&used)
+	toggle := "Отключить"
+	if promo.Disabled {
+		toggle = "Включить"
+	}
+	id := strconv.FormatInt(promo.ID, 10)
+	sendMessageKeyboard(bc, user.ID, formatPromoCode(promo)+"\nИспользован: "+strconv.FormatInt(used, 10),
+		tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(toggle, "promotoggle:"+id)),
+			tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Назад", "promos")),
+		),
+	)
+}
+
+func handlePromoAdminCallback(bc BotController, update tgbotapi.Update, user User) {
+	data := update.CallbackQuery.Data
+	if data == "promos" {
+		handlePromoPanel(bc, user)
+		return
+	}
+	if data == "promonew" {
+		// placeholder keeps code unique until admin enters the real one
+		placeholder := "DRAFT:" + strconv.FormatInt(time.Now().UnixNano(), 10)
+		promo, err := bc.CreatePromoCode(PromoCode{Code: placeholder, Draft: true, Disabled: true})
+		if err != nil {
+			log.Printf("Error creating promo code draft: %s\n", err)
+			return
+		}
+		askPromoDraftStep(bc, user, promo, promoDraftSteps[0])
+		return
+	}
+
+	tokens := strings.Split(data, ":")
+	if len(tokens) < 2 {
+		return
+	}
+	promoID, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing promo code id: %s\n", err)
+		return
+	}
+	promo, err := bc.GetPromoCode(promoID)
+	if err != nil {
+		return
+	}
+
+	switch tokens[0] {
+	case "promoview":
+		handlePromoView(bc, user, promo)
+	case "promotoggle":
+		promo.Disabled = !promo.Disabled
+		bc.UpdatePromoCode(promo)
+		handlePromoView(bc, user, promo)
+	case "promosave":
+		if !promo.Draft {
+			return
+		}
+		promo.Draft = false
+		promo.Disabled = false
+		if err := bc.UpdatePromoCode(promo); err != nil {
+			log.Printf("Error saving promo code: %s\n", err)
+			sendMessage(bc, user.ID, "Something went wrong, try again...")
+			return
+		}
+		resetState(bc, user)
+		sendMessage(bc, user.ID, "Промокод сохранён")
+		handlePromoView(bc, user, promo)
+	case "promocancel":
+		if promo.Draft {
+			bc.DeletePromoCode(promo.ID)
+		}
+		resetState(bc, user)
+		sendMessage(bc, user.ID, "Отменено")
+	}
+}
+
+func askPromoDraftStep(bc BotController, user User, promo PromoCode, step string) {
+	setState(bc, user, "promodraft", StatePayload{PromoID: promo.ID, Step: step})
+	sendMessage(bc, user.ID, promoDraftPrompts[step]+"\nSay /cancel to cancel action")
+}
+
+func handlePromoDraftMessage(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+	step := payload.Step
+	promo, err := bc.GetPromoCode(payload.PromoID)
+	if err != nil || !promo.Draft {
+		resetState(bc, user)
+		sendMessage(bc, user.ID, "Черновик не найден, начните заново через /panel")
+		return
+	}
+
+	if err := applyPromoDraftStep(bc, &promo, step, strings.TrimSpace(update.Message.Text)); err != nil {
+		sendMessage(bc, user.ID, err.Error())
+		return
+	}
+	if err := bc.UpdatePromoCode(promo); err != nil {
+		log.Printf("Error updating promo code draft: %s\n", err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+
+	for i, s := range promoDraftSteps {
+		if s == step && i+1 < len(promoDraftSteps) {
+			askPromoDraftStep(bc, user, promo, promoDraftSteps[i+1])
+			return
+		}
+	}
+
+	setState(bc, user, "promodraft", StatePayload{PromoID: promo.ID, Step: "confirm"})
+	id := strconv.FormatInt(promo.ID, 10)
+	sendMessageKeyboard(bc, user.ID, formatPromoCode(promo)+"\n\nСохранить?",
+		tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Сохранить", "promosave:"+id),
+			tgbotapi.NewInlineKeyboardButtonData("Отмена", "promocancel:"+id),
+		)),
+	)
+}
+
+func applyPromoDraftStep(bc BotController, promo *PromoCode, step string, text string) error {
+	switch step {
+	case "code":
+		code := strings.ToUpper(text)
+		if code == "" || strings.ContainsAny(code, " :") {
+			return errors.New("Промокод не должен быть пустым или содержать пробелы")
+		}
+		if _, err := bc.GetPromoCodeByCode(code); err == nil {
+			return errors.New("Такой промокод уже существует")
+		}
+		promo.Code = code
+	case "discount":
+		if percent, found := strings.CutSuffix(text, "%"); found {
+			value, err := strconv.ParseInt(strings.TrimSpace(percent), 10, 64)
+			if err != nil || value <= 0 || value > 100 {
+				return errors.New("Процент должен быть от 1 до 100")
+			}
+			promo.DiscountType = PercentDiscount
+			promo.Discount = value
+		} else {
+			value, currency, err := parsePrice(text, "AED")
+			if err != nil || value <= 0 {
+				return errors.New("Неверная сумма скидки, пример: 50, 100 XTR или 10%")
+			}
+			promo.DiscountType = FixedDiscount
+			promo.Discount = value
+			promo.Currency = currency
+		}
+	case "limit", "userlimit":
+		value, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || value < 0 {
+			return errors.New("Введите неотрицательное число")
+		}
+		if step == "limit" {
+			promo.UsageLimit = value
+		} else {
+			promo.PerUserLimit = value
+		}
+	case "validity":
+		if text == "-" {
+			promo.ValidFrom = nil
+			promo.ValidUntil = nil
+			return nil
+		}
+		fromstr, untilstr, found := strings.Cut(text, "-")
+		errFormat := errors.New("Неверный формат, пример: 01.04.2025-30.04.2025")
+		if !found {
+			return errFormat
+		}
+		from, err := time.ParseInLocation("02.01.2006", strings.TrimSpace(fromstr), dubaiLocation)
+		if err != nil {
+			return errFormat
+		}
+		until, err := time.ParseInLocation("02.01.2006", strings.TrimSpace(untilstr), dubaiLocation)
+		if err != nil {
+			return errFormat
+		}
+		until = until.Add(24*time.Hour - time.Second) // code is valid until the end of last day
+		if until.Before(from) {
+			return errors.New("Дата окончания раньше даты начала")
+		}
+		promo.ValidFrom = &from
+		promo.ValidUntil = &until
+	case "event":
+		if text == "-" {
+			promo.EventID = 0
+			return nil
+		}
+		eventID, err := strconv.ParseInt(strings.TrimPrefix(text, "#"), 10, 64)
+		if err != nil {
+			return errors.New("Введите номер мероприятия или -")
+		}
+		if _, err := bc.GetEvent(eventID); err != nil {
+			return errors.New("Мероприятие не найдено")
+		}
+		promo.EventID = eventID
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// canRequestRefund reports whether user may ask to refund paid reservation
+func canRequestRefund(bc BotController, reservation Reservation, event Event) bool {
+	return reservation.Status == Paid && time.Until(*event.Date) > bc.cfg.RefundCutoff
+}
+
+// handleRefundRequestCallback lets user ask for refund of paid reservation
+func handleRefundRequestCallback(bc BotController, update tgbotapi.Update, user User) {
+	tokens := strings.Split(update.CallbackQuery.Data, ":")
+	reservationID, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing reservation token: %s\n", err)
+		return
+	}
+	reservation, err := bc.GetReservationByID(reservationID)
+	if err != nil || reservation.UserID != user.ID {
+		return
+	}
+	event, err := bc.GetEvent(reservation.EventID)
+	if err != nil {
+		return
+	}
+	if !canRequestRefund(bc, reservation, event) {
+		sendMessage(bc, user.ID, tr(user.Locale, "Возврат по этой брони уже невозможен, свяжитесь с поддержкой"))
+		return
+	}
+
+	if tokens[0] == "refundreq" {
+		sendMessageKeyboard(bc, user.ID, fmt.Sprintf(tr(user.Locale, "Отменить бронь на %s и вернуть оплату?"), formatEventDateLocale(user.Locale, event)),
+			tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(tr(user.Locale, "Да, вернуть"), "refundreqconfirm:"+tokens[1]),
+				tgbotapi.NewInlineKeyboardButtonData(tr(user.Locale, "Нет"), "mybookings"),
+			)),
+		)
+		return
+	}
+
+	requested, err := bc.ChangeReservationStatus(reservation.ID, Paid, RefundRequested)
+	if err != nil || !requested {
+		log.Printf("Error requesting refund of reservation %d: %v\n", reservation.ID, err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+	sendBotContent(bc, user.ID, user.Locale, "refund_requested_message", userTemplateVars(bc, user.ID))
+
+	ui, _ := bc.GetUserInfo(user.ID)
+	notifySupportChatKeyboard(bc, fmt.Sprintf(
+		"Пользователь %s (%s) просит вернуть оплату брони #%d на %s %s, имя: %s, сумма: %s",
+		ui.FirstName,
+		ui.Username,
+		reservation.ID,
+		event.Title,
+		formatEventDate(event),
+		reservation.EnteredName,
+		formatPrice(bc.ReservationPrice(reservation, event), event.Currency),
+	), tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Вернуть", "dorefund:"+tokens[1]),
+		tgbotapi.NewInlineKeyboardButtonData("Отказать", "denyrefund:"+tokens[1]),
+	)))
+}
+
+// handleRefundAdminCallback handles admin's decision on refund, both from support chat and panel
+func handleRefundAdminCallback(bc BotController, update tgbotapi.Update, user User) {
+	if !user.IsAdmin() {
+		return
+	}
+	tokens := strings.Split(update.CallbackQuery.Data, ":")
+	reservationID, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing reservation token: %s\n", err)
+		return
+	}
+	reservation, err := bc.GetReservationByID(reservationID)
+	if err != nil {
+		return
+	}
+
+	var result string
+	switch tokens[0] {
+	case "dorefund":
+		refunded, err := refundReservation(bc, reservation)
+		switch {
+		case err != nil:
+			result = "Ошибка возврата: " + err.Error()
+		case refunded:
+			result = "Возвращено"
+		default:
+			result = "Ожидает ручного возврата"
+		}
+	case "refunddone":
+		if finishRefund(bc, reservation) {
+			result = "Возврат подтверждён"
+		} else {
+			reservation, _ = bc.GetReservationByID(reservation.ID)
+			result = "Бронь уже " + ReservationStatusString[reservation.Status]
+		}
+	default:
+		denied, _ := bc.ChangeReservationStatus(reservation.ID, RefundRequested, Paid)
+		if denied {
+			sendBotContent(bc, reservation.UserID, bc.UserLocale(reservation.UserID), "refund_denied_message", userTemplateVars(bc, reservation.UserID))
+		}
+		result = "Отказано"
+	}
+
+	msg := update.CallbackQuery.Message
+	if msg != nil && msg.Chat.ID != user.ID {
+		// replace buttons in support chat so refund isn't processed twice
+		bc.bot.Send(tgbotapi.NewEditMessageText(msg.Chat.ID, msg.MessageID,
+			fmt.Sprintf("%s\n\n%s (%s)", msg.Text, result, update.CallbackQuery.From.UserName)))
+	} else {
+		sendMessage(bc, user.ID, result)
+	}
+}
+
+// refundReservation returns money of paid reservation and releases its seat.
+// Telegram Stars are refunded through Bot API and reservation is refunded at once.
+// Other payments are returned by support manually, reservation stays Refunding until
+// admin confirms it in support chat, then false is returned.
+func refundReservation(bc BotController, reservation Reservation) (bool, error) {
+	if reservation.Status != Paid && reservation.Status != RefundRequested {
+		return false, fmt.Errorf("бронь %s", ReservationStatusString[reservation.Status])
+	}
+	event, _ := bc.GetEvent(reservation.EventID)
+
+	// status is claimed before money is returned, so repeated tap doesn't refund twice
+	claimed, err := bc.ChangeReservationStatus(reservation.ID, reservation.Status, Refunding)
+	if err != nil {
+		return false, err
+	}
+	if !claimed {
+		return false, fmt.Errorf("бронь изменилась, попробуйте ещё раз")
+	}
+
+	if event.Currency == "XTR" && reservation.TelegramChargeID != "" {
+		_, err := bc.bot.MakeRequest("refundStarPayment", tgbotapi.Params{
+			"user_id":                    strconv.FormatInt(reservation.UserID, 10),
+			"telegram_payment_charge_id": reservation.TelegramChargeID,
+		})
+		if err != nil {
+			log.Printf("Error refunding stars of reservation %d: %s\n", reservation.ID, err)
+			bc.ChangeReservationStatus(reservation.ID, Refunding, reservation.Status)
+			return false, err
+		}
+	} else if reservation.TelegramChargeID != "" || reservation.ProviderChargeID != "" {
+		sent := notifySupportChatKeyboard(bc, fmt.Sprintf(
+			"Верните оплату брони #%d вручную: %s, telegram charge: %s, provider charge: %s",
+			reservation.ID,
+			formatPrice(bc.ReservationPrice(reservation, event), event.Currency),
+			reservation.TelegramChargeID,
+			reservation.ProviderChargeID,
+		), tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Деньги возвращены", "refunddone:"+strconv.FormatInt(reservation.ID, 10)),
+		)))
+		if !sent {
+			bc.ChangeReservationStatus(reservation.ID, Refunding, reservation.Status)
+			return false, fmt.Errorf("чат поддержки недоступен")
+		}
+		return false, nil
+	}
+
+	finishRefund(bc, reservation)
+	return true, nil
+}
+
+// finishRefund marks reservation refunded after money is returned and gives its seat to waitlist
+func finishRefund(bc BotController, reservation Reservation) bool {
+	refunded, err := bc.ChangeReservationStatus(reservation.ID, Refunding, Refunded)
+	if err != nil {
+		log.Printf("Error finishing refund of reservation %d: %s\n", reservation.ID, err)
+	}
+	if !refunded {
+		return false
+	}
+	sendBotContent(bc, reservation.UserID, bc.UserLocale(reservation.UserID), "refunded_message", userTemplateVars(bc, reservation.UserID))
+	promoteWaitlist(bc, reservation.EventID)
+	return true
+}
+
+// handleEventReservations lists reservations of event with refund buttons for admin
+func handleEventReservations(bc BotController, user User, event Event) {
+	reservations, _ := bc.GetReservationsByEventID(event.ID)
+	lines := []string{"Брони мероприятия " + formatEventDate(event)}
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	for _, reservation := range reservations {
+		ui, _ := bc.GetUserInfo(reservation.UserID)
+		lines = append(lines, fmt.Sprintf("#%d %s (@%s), имя: %s - %s",
+			reservation.ID, ui.FirstName, ui.Username, reservation.EnteredName,
+			ReservationStatusString[reservation.Status]))
+		if reservation.Status == Paid || reservation.Status == RefundRequested {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("Вернуть #%d", reservation.ID), "dorefund:"+strconv.FormatInt(reservation.ID, 10),
+			)))
+		}
+	}
+	id := strconv.FormatInt(event.ID, 10)
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Отменить мероприятие и вернуть всем", "eventrefundall:"+id)),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Назад", "eventview:"+id)),
+	)
+	sendMessageKeyboard(bc, user.ID, strings.Join(lines, "\n"), tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// refundEvent cancels event: hides it, refunds paid reservations and cancels unpaid ones
+func refundEvent(bc BotController, user User, event Event) {
+	event.Hidden = true
+	bc.UpdateEvent(event)
+	// nobody may be offered seat of cancelled event
+	if err := bc.CloseWaitlist(event.ID); err != nil {
+		log.Printf("Error closing waitlist of event %d: %s\n", event.ID, err)
+	}
+
+	reservations, _ := bc.GetReservationsByEventID(event.ID)
+	refunded, manual, cancelled, failed := 0, 0, 0, 0
+	for _, reservation := range reservations {
+		switch reservation.Status {
+		case Paid, RefundRequested:
+			done, err := refundReservation(bc, reservation)
+			switch {
+			case err != nil:
+				failed++
+			case done:
+				refunded++
+			default:
+				manual++
+			}
+		case Booked:
+			if ok, _ := bc.CancelReservation(reservation.ID, Booked); ok {
+				locale := bc.UserLocale(reservation.UserID)
+				content := bc.RenderBotContent(locale, "event_cancelled_message",
+					userTemplateVars(bc, reservation.UserID).WithReservation(bc, locale, reservation, event))
+				content.Text += "\n\n" + eventDetails(locale, event)
+				sendContent(bc, reservation.UserID, content, nil)
+				cancelled++
+			}
+		}
+	}
+	sendMessage(bc, user.ID, fmt.Sprintf("Мероприятие скрыто. Возвращено: %d, ожидают ручного возврата: %d, отменено броней: %d, ошибок: %d",
+		refunded, manual, cancelled, failed))
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type reminderPayload struct {
+	Offset string `json:"offset"`
+}
+
+// parseReminderOffsets parses comma separated durations like "24h, 8h, 30m", result is sorted from the earliest reminder
+func parseReminderOffsets(s string) ([]time.Duration, error) {
+	offsets := []time.Duration{}
+	seen := map[time.Duration]bool{}
+	for _, token := range strings.Split(s, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		offset, err := time.ParseDuration(token)
+		if err != nil || offset <= 0 {
+			return nil, errors.New("Неверный интервал: " + token + ". Пример: 24h, 8h, 30m")
+		}
+		if !seen[offset] {
+			seen[offset] = true
+			offsets = append(offsets, offset)
+		}
+	}
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] > offsets[j] })
+	return offsets, nil
+}
+
+// formatReminderOffset formats offset short, it is also used in reminder literals, e.g. notify_pre_event_24h
+func formatReminderOffset(offset time.Duration) string {
+	if offset%time.Hour == 0 {
+		return strconv.FormatInt(int64(offset/time.Hour), 10) + "h"
+	}
+	if offset%time.Minute == 0 {
+		return strconv.FormatInt(int64(offset/time.Minute), 10) + "m"
+	}
+	return offset.String()
+}
+
+func formatReminderOffsets(offsets []time.Duration) string {
+	formatted := []string{}
+	for _, offset := range offsets {
+		formatted = append(formatted, formatReminderOffset(offset))
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func eventReminderOffsets(bc BotController, event Event) []time.Duration {
+	if event.ReminderOffsets != "" {
+		if offsets, err := parseReminderOffsets(event.ReminderOffsets); err == nil {
+			return offsets
+		}
+		log.Printf("Error parsing reminder offsets of event %d: %s\n", event.ID, event.ReminderOffsets)
+	}
+	offsets, err := parseReminderOffsets(bc.cfg.ReminderOffsets)
+	if err != nil {
+		log.Printf("Error parsing default reminder offsets: %s\n", err)
+	}
+	return offsets
+}
+
+func eventReminderTasks(bc BotController, event Event) []Task {
+	tasks := []Task{}
+	for _, offset := range eventReminderOffsets(bc, event) {
+		key := formatReminderOffset(offset)
+		payload, _ := json.Marshal(reminderPayload{Offset: key})
+		tasks = append(tasks, Task{
+			Type:     NotifyAboutEvent,
+			EventID:  event.ID,
+			DedupKey: taskKey("notify:" + strconv.FormatInt(event.ID, 10) + ":" + key),
+			Payload:  string(payload),
+			RunAt:    event.Date.Add(-offset),
+		})
+	}
+	return tasks
+}
+
+// ensureEventReminders plans reminders of event which are not planned yet
+func ensureEventReminders(bc BotController, event Event) {
+	for _, task := range eventReminderTasks(bc, event) {
+		if err := bc.EnsureTask(task); err != nil {
+			log.Printf("Error scheduling reminder of event %d: %s\n", event.ID, err)
+		}
+	}
+}
+
+// scheduleEventReminders (re)plans reminders of event, should be called when event is created,
+// rescheduled or its reminder offsets are changed
+func scheduleEventReminders(bc BotController, event Event) {
+	keys := []string{}
+	for _, task := range eventReminderTasks(bc, event) {
+		keys = append(keys, *task.DedupKey)
+		if err := bc.ScheduleTask(task); err != nil {
+			log.Printf("Error scheduling reminder of event %d: %s\n", event.ID, err)
+		}
+	}
+	if err := bc.DeleteStaleEventTasks(event.ID, NotifyAboutEvent, keys); err != nil {
+		log.Printf("Error removing old reminders of event %d: %s\n", event.ID, err)
+	}
+}
+
+// reminderLiteral is literal for offset, or literal of default reminder text if it's not set
+func reminderLiteral(bc BotController, locale string, offset string) string {
+	literal := reminderLiteralPrefix + offset
+	if _, err := bc.GetBotContentVerboseLocale(locale, literal); err != nil {
+		return "notify_pre_event"
+	}
+	return literal
+}
+
+// reminderOffsetLabels are offsets of default and upcoming events reminders, as written in their literals,
+// longest first like in parseReminderOffsets
+func reminderOffsetLabels(bc BotController) []string {
+	offsets := map[time.Duration]bool{}
+	defaults, _ := parseReminderOffsets(bc.cfg.ReminderOffsets)
+	for _, offset := range defaults {
+		offsets[offset] = true
+	}
+	events, _ := bc.GetAllEvents()
+	for _, event := range events {
+		if event.Date.Before(time.Now()) {
+			continue
+		}
+		for _, offset := range eventReminderOffsets(bc, event) {
+			offsets[offset] = true
+		}
+	}
+
+	sorted := []time.Duration{}
+	for offset := range offsets {
+		sorted = append(sorted, offset)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+	result := []string{}
+	for _, offset := range sorted {
+		result = append(result, formatReminderOffset(offset))
+	}
+	return result
+}
+
+func handleNotifyAboutEventTask(ctx context.Context, bc BotController, task Task) (time.Time, error) {
+	var payload reminderPayload
+	if err := json.Unmarshal([]byte(task.Payload), &payload); err != nil || payload.Offset == "" {
+		log.Printf("Skipping reminder task %d with bad payload: %s\n", task.ID, task.Payload)
+		return time.Time{}, nil
+	}
+	event, err := bc.GetEvent(task.EventID)
+	if err != nil || event.Date.Before(time.Now()) {
+		// event is deleted or already passed, nothing to remind about
+		return time.Time{}, nil
+	}
+
+	due := false
+	for _, offset := range eventReminderOffsets(bc, event) {
+		if formatReminderOffset(offset) == payload.Offset {
+			due = true
+		} else if due && time.Until(*event.Date) <= offset {
+			// bot was down and later reminder is due too, send only the later one
+			log.Printf("Skipping reminder %s of event %d, %s is due too\n", payload.Offset, event.ID, formatReminderOffset(offset))
+			return time.Time{}, nil
+		}
+	}
+	if !due {
+		// offset was removed from event
+		return time.Time{}, nil
+	}
+
+	reservations, err := bc.GetReservationsByEventID(event.ID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	failed := 0
+	for _, reservation := range reservations {
+		if !reservation.HoldsSeat() {
+			continue
+		}
+		claimed, err := bc.ClaimReminderDelivery(reservation, payload.Offset)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !claimed {
+			continue
+		}
+
+		locale := bc.UserLocale(reservation.UserID)
+		content := bc.RenderBotContent(locale, reminderLiteral(bc, locale, payload.Offset),
+			userTemplateVars(bc, reservation.UserID).WithReservation(bc, locale, reservation, event))
+		content.Text += "\n\n" + eventDetails(locale, event)
+		err = sendContent(bc, reservation.UserID, content, nil)
+		var tgerr *tgbotapi.Error
+		if err != nil && !(errors.As(err, &tgerr) && tgerr.Code == 403) {
+			// will be sent again on retry, users who blocked bot are not retried
+			log.Printf("Error sending reminder to %d: %s\n", reservation.UserID, err)
+			bc.ReleaseReminderDelivery(reservation, payload.Offset)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return time.Time{}, fmt.Errorf("reminder %s of event %d is not delivered to %d users", payload.Offset, event.ID, failed)
+	}
+	return time.Time{}, nil
+}
+
+// handleEventRemindersMessage sets reminder offsets of event entered by admin
+func handleEventRemindersMessage(bc BotController, update tgbotapi.Update, user User, payload StatePayload) {
+	event, err := bc.GetEvent(payload.EventID)
+	if err != nil {
+		resetState(bc, user)
+		sendMessage(bc, user.ID, "Мероприятие не найдено")
+		return
+	}
+
+	text := strings.TrimSpace(update.Message.Text)
+	if text == "-" {
+		event.ReminderOffsets = ""
+	} else {
+		offsets, err := parseReminderOffsets(text)
+		if err != nil || len(offsets) == 0 {
+			sendMessage(bc, user.ID, "Введите интервалы через запятую, например: 24h, 8h, 30m")
+			return
+		}
+		event.ReminderOffsets = formatReminderOffsets(offsets)
+	}
+	if err := bc.UpdateEvent(event); err != nil {
+		log.Printf("Error saving event: %s\n", err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+	scheduleEventReminders(bc, event)
+	resetState(bc, user)
+	handleEventView(bc, user, event.ID)
+}
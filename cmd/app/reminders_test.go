@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseReminderOffsets(t *testing.T) {
+	offsets, err := parseReminderOffsets(" 1h, 24h,30m,1h ")
+	if err != nil {
+		t.Fatalf("parse offsets: %s", err)
+	}
+	if got := formatReminderOffsets(offsets); got != "24h, 1h, 30m" {
+		t.Errorf("offsets are %q", got)
+	}
+	if _, err := parseReminderOffsets("8 hours"); err == nil {
+		t.Error("bad offset is accepted")
+	}
+	if _, err := parseReminderOffsets("-1h"); err == nil {
+		t.Error("negative offset is accepted")
+	}
+}
+
+func runReminderTasks(t *testing.T, bc BotController, now time.Time) {
+	t.Helper()
+	for {
+		task, ok, err := bc.ClaimTask(now, time.Minute)
+		if err != nil {
+			t.Fatalf("claim task: %s", err)
+		}
+		if !ok {
+			return
+		}
+		runTask(context.Background(), bc, task)
+	}
+}
+
+func TestReminderSentOnce(t *testing.T) {
+	bc := newTestBotController(t)
+	bot, fake := newFakeBotAPI(t)
+	bc.bot = bot
+	bc.cfg.ReminderOffsets = "48h,24h"
+
+	event := createTestEvent(t, bc, 5) // starts in 24h
+	for _, userID := range []int64{1, 2} {
+		if _, err := bc.BookSeat(userID, event.ID, "name"); err != nil {
+			t.Fatalf("book seat: %s", err)
+		}
+	}
+	scheduleEventReminders(bc, event)
+
+	// both reminders are overdue, as if bot was down, only the later one is sent
+	runReminderTasks(t, bc, time.Now())
+	if sent := len(fake.find("sendMessage")); sent != 2 {
+		t.Fatalf("%d reminders sent, want 2", sent)
+	}
+
+	// task is rerun, e.g. after crash before it was finished
+	bc.db.Model(&Task{}).Where("1 = 1").Update("status", TaskPending)
+	runReminderTasks(t, bc, time.Now())
+	if sent := len(fake.find("sendMessage")); sent != 2 {
+		t.Fatalf("%d reminders sent after rerun, want 2", sent)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Handler handles update from user, user is loaded by middleware
+type Handler func(bc BotController, update tgbotapi.Update, user User)
+
+// IntHandler handles callback which first parameter is id, e.g. "eventview:42"
+type IntHandler func(bc BotController, update tgbotapi.Update, user User, id int64)
+
+// Middleware wraps handler, e.g. to check permissions or recover from panic
+type Middleware func(next Handler) Handler
+
+type messageRoute struct {
+	match   func(bc BotController, msg *tgbotapi.Message) bool
+	handler Handler
+}
+
+// Router dispatches updates to handlers registered for commands, callback actions and message types
+type Router struct {
+	middleware  []Middleware
+	commands    map[string]Handler
+	callbacks   map[string]Handler // by action, part of callback data before first ':'
+	messages    []messageRoute
+	fallback    Handler // messages not matched by other routes, e.g. answers in dialog states
+	preCheckout Handler
+	channelPost Handler
+}
+
+func NewRouter() *Router {
+	return &Router{
+		commands:  map[string]Handler{},
+		callbacks: map[string]Handler{},
+	}
+}
+
+func chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Use adds middleware applied to every update, first added is outermost
+func (r *Router) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Command registers handler of command, e.g. "/start"
+func (r *Router) Command(command string, h Handler, middleware ...Middleware) {
+	r.commands[command] = chain(h, middleware...)
+}
+
+// Callback registers handler of callback queries with data "action" or "action:params..."
+func (r *Router) Callback(action string, h Handler, middleware ...Middleware) {
+	r.callbacks[action] = chain(h, middleware...)
+}
+
+// CallbackInt registers handler of callback queries with data "action:id", callbacks with invalid id are ignored
+func (r *Router) CallbackInt(action string, h IntHandler, middleware ...Middleware) {
+	r.Callback(action, func(bc BotController, update tgbotapi.Update, user User) {
+		id, err := callbackIntArg(update.CallbackQuery.Data, 0)
+		if err != nil {
+			log.Printf("Error parsing callback %s: %s\n", update.CallbackQuery.Data, err)
+			return
+		}
+		h(bc, update, user, id)
+	}, middleware...)
+}
+
+// Message registers handler of messages matched by match, routes are checked in order of registration
+func (r *Router) Message(match func(bc BotController, msg *tgbotapi.Message) bool, h Handler, middleware ...Middleware) {
+	r.messages = append(r.messages, messageRoute{match: match, handler: chain(h, middleware...)})
+}
+
+// Fallback registers handler of messages which are neither commands nor matched by Message routes
+func (r *Router) Fallback(h Handler, middleware ...Middleware) {
+	r.fallback = chain(h, middleware...)
+}
+
+func (r *Router) PreCheckout(h Handler, middleware ...Middleware) {
+	r.preCheckout = chain(h, middleware...)
+}
+
+func (r *Router) ChannelPost(h Handler, middleware ...Middleware) {
+	r.channelPost = chain(h, middleware...)
+}
+
+// callbackIntArg parses i-th parameter of callback data, parameters go after action separated by ':'
+func callbackIntArg(data string, i int) (int64, error) {
+	tokens := strings.Split(data, ":")
+	if len(tokens) < i+2 {
+		return 0, fmt.Errorf("callback %s has no parameter %d", data, i)
+	}
+	return strconv.ParseInt(tokens[i+1], 10, 64)
+}
+
+// find returns handler of update, or nil if nothing is registered for it
+func (r *Router) find(bc BotController, update tgbotapi.Update) Handler {
+	switch {
+	case update.Message != nil:
+		for _, route := range r.messages {
+			if route.match(bc, update.Message) {
+				return route.handler
+			}
+		}
+		if strings.HasPrefix(update.Message.Text, "/") {
+			// unknown commands are ignored
+			return r.commands["/"+update.Message.Command()]
+		}
+		return r.fallback
+	case update.CallbackQuery != nil:
+		action, _, _ := strings.Cut(update.CallbackQuery.Data, ":")
+		return r.callbacks[action]
+	case update.PreCheckoutQuery != nil:
+		return r.preCheckout
+	case update.ChannelPost != nil:
+		return r.channelPost
+	}
+	return nil
+}
+
+// Route passes update through middleware to its handler
+func (r *Router) Route(bc BotController, update tgbotapi.Update) {
+	h := r.find(bc, update)
+	if h == nil {
+		// middleware still runs, e.g. to log message and answer callback
+		h = func(bc BotController, update tgbotapi.Update, user User) {}
+	}
+	chain(h, r.middleware...)(bc, update, User{})
+}
+
+func isPayment(bc BotController, msg *tgbotapi.Message) bool {
+	return msg.SuccessfulPayment != nil
+}
+
+// recoverMiddleware keeps bot running if handler panics, admins are notified
+func recoverMiddleware(next Handler) Handler {
+	return func(bc BotController, update tgbotapi.Update, user User) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic while handling update %d: %v\n%s", update.UpdateID, r, debug.Stack())
+				notifyAdminAboutError(bc, fmt.Sprintf("Panic while handling update %d: %v", update.UpdateID, r))
+			}
+		}()
+		next(bc, update, user)
+	}
+}
+
+// loadUserMiddleware loads sender of update from DB, creating new users
+func loadUserMiddleware(next Handler) Handler {
+	return func(bc BotController, update tgbotapi.Update, user User) {
+		if from := update.SentFrom(); from != nil {
+			user = bc.GetUser(from.ID)
+		}
+		next(bc, update, user)
+	}
+}
+
+// userInfoMiddleware keeps names of user up to date
+func userInfoMiddleware(next Handler) Handler {
+	return func(bc BotController, update tgbotapi.Update, user User) {
+		if from := update.SentFrom(); from != nil {
+			bc.UpdateUserInfo(GetUserInfo(from))
+		}
+		next(bc, update, user)
+	}
+}
+
+// logMiddleware saves messages to DB and logs commands and callbacks
+func logMiddleware(next Handler) Handler {
+	return func(bc BotController, update tgbotapi.Update, user User) {
+		if update.Message != nil {
+			bc.LogMessage(update)
+			if update.Message.IsCommand() {
+				log.Printf("[%s] %s", update.Message.From.UserName, update.Message.Text)
+			}
+		} else if update.CallbackQuery != nil {
+			log.Printf("[%s] callback %s", update.CallbackQuery.From.UserName, update.CallbackQuery.Data)
+		}
+		next(bc, update, user)
+	}
+}
+
+// answerCallbackMiddleware answers callback query after handler, so button stops loading
+func answerCallbackMiddleware(next Handler) Handler {
+	return func(bc BotController, update tgbotapi.Update, user User) {
+		if update.CallbackQuery != nil {
+			defer bc.bot.Request(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+		}
+		next(bc, update, user)
+	}
+}
+
+// requireAdmin passes only users with admin rights
+func requireAdmin(next Handler) Handler {
+	return func(bc BotController, update tgbotapi.Update, user User) {
+		if user.IsAdmin() {
+			next(bc, update, user)
+		}
+	}
+}
+
+// requireEffectiveAdmin passes only admins which are not in user mode
+func requireEffectiveAdmin(next Handler) Handler {
+	return func(bc BotController, update tgbotapi.Update, user User) {
+		if user.IsEffectiveAdmin() {
+			next(bc, update, user)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestRouterCommandMiddleware(t *testing.T) {
+	bc, _ := newStateTestBotController(t)
+	calls := 0
+	r := NewRouter()
+	r.Use(loadUserMiddleware)
+	r.Command("/panel", func(bc BotController, update tgbotapi.Update, user User) { calls++ }, requireAdmin)
+
+	r.Route(bc, commandMessage(1, 1, "/panel"))
+	if calls != 0 {
+		t.Fatal("admin command is handled for non-admin")
+	}
+
+	user := bc.GetUser(1)
+	bc.db.Model(&user).Update("role_bitmask", 0b11)
+	r.Route(bc, commandMessage(1, 2, "/panel"))
+	r.Route(bc, commandMessage(1, 3, "/unknown"))
+	if calls != 1 {
+		t.Fatalf("command is handled %d times, want 1", calls)
+	}
+}
+
+func TestRouterCallbackActions(t *testing.T) {
+	bc, fake := newStateTestBotController(t)
+	var got []string
+	var ids []int64
+	r := NewRouter()
+	r.Use(answerCallbackMiddleware)
+	r.Callback("events", func(bc BotController, update tgbotapi.Update, user User) { got = append(got, "events") })
+	r.CallbackInt("eventview", func(bc BotController, update tgbotapi.Update, user User, id int64) {
+		got = append(got, "eventview")
+		ids = append(ids, id)
+	})
+
+	r.Route(bc, callbackUpdate(1, "events"))
+	r.Route(bc, callbackUpdate(1, "eventview:42"))
+	r.Route(bc, callbackUpdate(1, "eventview:abc"))
+	r.Route(bc, callbackUpdate(1, "eventviews:42"))
+	if len(got) != 2 || got[0] != "events" || got[1] != "eventview" || ids[0] != 42 {
+		t.Fatalf("handled %v with ids %v", got, ids)
+	}
+	// every callback is answered, even unhandled ones
+	if answers := fake.find("answerCallbackQuery"); len(answers) != 4 {
+		t.Fatalf("%d callbacks answered, want 4", len(answers))
+	}
+}
+
+func TestRouterMessageRoutes(t *testing.T) {
+	bc, _ := newStateTestBotController(t)
+	var got []string
+	r := NewRouter()
+	r.Message(isPayment, func(bc BotController, update tgbotapi.Update, user User) { got = append(got, "payment") })
+	r.Command("/start", func(bc BotController, update tgbotapi.Update, user User) { got = append(got, "start") })
+	r.Fallback(func(bc BotController, update tgbotapi.Update, user User) { got = append(got, "fallback") })
+
+	payment := userMessage(1, 1, "")
+	payment.Message.SuccessfulPayment = &tgbotapi.SuccessfulPayment{}
+	r.Route(bc, payment)
+	r.Route(bc, commandMessage(1, 2, "/start"))
+	r.Route(bc, userMessage(1, 3, "hello"))
+	if len(got) != 3 || got[0] != "payment" || got[1] != "start" || got[2] != "fallback" {
+		t.Fatalf("routed to %v", got)
+	}
+}
+
+func TestRouterRecoversPanic(t *testing.T) {
+	bc, _ := newStateTestBotController(t)
+	r := NewRouter()
+	r.Use(recoverMiddleware)
+	r.Callback("boom", func(bc BotController, update tgbotapi.Update, user User) { panic("boom") })
+
+	r.Route(bc, callbackUpdate(1, "boom"))
+}
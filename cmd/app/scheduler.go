@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	taskPollInterval = 5 * time.Second
+	// worker holds claimed task this long, after that task is considered abandoned
+	taskLockDuration  = 10 * time.Minute
+	taskRetryBase     = 30 * time.Second
+	taskRetryMax      = time.Hour
+	sheetSyncInterval = 60 * time.Second
+)
+
+// TaskHandler runs task. Returned error makes task retried later with backoff.
+// Non-zero returned time reschedules task instead of finishing it.
+// Long handlers should return early after ctx is done, unfinished work is rescheduled.
+type TaskHandler func(ctx context.Context, bc BotController, task Task) (time.Time, error)
+
+var taskHandlers = map[TaskType]TaskHandler{
+	SyncSheet:        handleSyncSheetTask,
+	NotifyAboutEvent: handleNotifyAboutEventTask,
+	SendBroadcast:    handleSendBroadcastTask,
+}
+
+// runScheduler starts workers executing due tasks, tasks are stored in DB and survive restarts.
+// Workers stop after ctx is done and running tasks are finished.
+func runScheduler(ctx context.Context, wg *sync.WaitGroup, bc BotController, workers int) {
+	// recurring and already planned tasks, no-op if they exist
+	if err := bc.EnsureRecurringTask(Task{Type: SyncSheet, DedupKey: taskKey("syncsheet")}); err != nil {
+		log.Printf("Error scheduling sheet sync: %s\n", err)
+	}
+	events, _ := bc.GetAllEvents()
+	for _, event := range events {
+		if event.Date.After(time.Now()) {
+			ensureEventReminders(bc, event)
+		}
+	}
+
+	// broadcasts started before tasks were used for them
+	broadcasts, _ := bc.GetSendingBroadcasts()
+	for _, b := range broadcasts {
+		if err := bc.EnsureTask(broadcastTask(b)); err != nil {
+			log.Printf("Error scheduling broadcast %d: %s\n", b.ID, err)
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			taskWorker(ctx, bc)
+		}()
+	}
+}
+
+func taskWorker(ctx context.Context, bc BotController) {
+	for ctx.Err() == nil {
+		task, claimed, err := bc.ClaimTask(time.Now(), taskLockDuration)
+		if err != nil {
+			log.Printf("Error claiming task: %s\n", err)
+		}
+		if !claimed {
+			sleepContext(ctx, taskPollInterval)
+			continue
+		}
+		runTask(ctx, bc, task)
+	}
+}
+
+// sleepContext sleeps for d, returns false if ctx is done earlier
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func runTask(ctx context.Context, bc BotController, task Task) {
+	next, err := safeRunTask(ctx, bc, task)
+	if err == nil {
+		err = bc.FinishTask(task, next)
+		if err != nil {
+			log.Printf("Error finishing task %d: %s\n", task.ID, err)
+		}
+		return
+	}
+
+	log.Printf("Error running task %d (attempt %d): %s\n", task.ID, task.Attempts, err)
+	if err := bc.FailTask(task, err, time.Now().Add(taskBackoff(task.Attempts))); err != nil {
+		log.Printf("Error saving failed task %d: %s\n", task.ID, err)
+	}
+	if task.MaxAttempts > 0 && task.Attempts >= task.MaxAttempts {
+		notifyAdminAboutError(bc, fmt.Sprintf("Task %d (type %d) failed after %d attempts: %s", task.ID, task.Type, task.Attempts, err))
+	}
+}
+
+// safeRunTask runs handler of task, panic is turned into error so worker keeps running
+func safeRunTask(ctx context.Context, bc BotController, task Task) (next time.Time, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	handler, ok := taskHandlers[task.Type]
+	if !ok {
+		return time.Time{}, errors.New("unknown task type " + strconv.FormatInt(int64(task.Type), 10))
+	}
+	return handler(ctx, bc, task)
+}
+
+// taskBackoff is delay before next try after attempts failed tries
+func taskBackoff(attempts int) time.Duration {
+	delay := taskRetryBase
+	for i := 1; i < attempts && delay < taskRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > taskRetryMax {
+		delay = taskRetryMax
+	}
+	return delay
+}
+
+func taskKey(key string) *string {
+	return &key
+}
+
+func handleSyncSheetTask(ctx context.Context, bc BotController, task Task) (time.Time, error) {
+	if err := bc.SyncPaidUsersToSheet(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(sheetSyncInterval), nil
+}
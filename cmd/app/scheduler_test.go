@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClaimTaskOnce(t *testing.T) {
+	bc := newTestBotController(t)
+	const tasks = 10
+	for i := 0; i < tasks; i++ {
+		if err := bc.CreateSimpleTask(SyncSheet); err != nil {
+			t.Fatalf("create task: %s", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claimed := map[int64]int{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				task, ok, err := bc.ClaimTask(time.Now(), time.Minute)
+				if err != nil {
+					t.Errorf("claim task: %s", err)
+					return
+				}
+				if !ok {
+					return
+				}
+				mu.Lock()
+				claimed[task.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != tasks {
+		t.Fatalf("claimed %d tasks, want %d", len(claimed), tasks)
+	}
+	for id, n := range claimed {
+		if n != 1 {
+			t.Errorf("task %d claimed %d times", id, n)
+		}
+	}
+}
+
+func TestTaskRetryAndGiveUp(t *testing.T) {
+	bc := newTestBotController(t)
+	if err := bc.CreateTask(Task{Type: SyncSheet, MaxAttempts: 2}); err != nil {
+		t.Fatalf("create task: %s", err)
+	}
+
+	now := time.Now()
+	task, ok, _ := bc.ClaimTask(now, time.Minute)
+	if !ok {
+		t.Fatal("task is not claimed")
+	}
+	bc.FailTask(task, errors.New("boom"), now.Add(time.Minute))
+	if _, ok, _ := bc.ClaimTask(now, time.Minute); ok {
+		t.Fatal("task is claimed before retry time")
+	}
+
+	task, ok, _ = bc.ClaimTask(now.Add(2*time.Minute), time.Minute)
+	if !ok || task.Attempts != 2 || task.LastError != "boom" {
+		t.Fatalf("retry is not claimed: %+v", task)
+	}
+	bc.FailTask(task, errors.New("boom"), now.Add(3*time.Minute))
+	task, _, _ = bc.GetTask(task.ID)
+	if task.Status != TaskFailed {
+		t.Fatalf("task status is %d after last attempt, want failed", task.Status)
+	}
+}
+
+func TestAbandonedTaskReclaimed(t *testing.T) {
+	bc := newTestBotController(t)
+	bc.CreateSimpleTask(SyncSheet)
+
+	now := time.Now()
+	if _, ok, _ := bc.ClaimTask(now, time.Minute); !ok {
+		t.Fatal("task is not claimed")
+	}
+	// worker died without finishing task
+	if _, ok, _ := bc.ClaimTask(now.Add(30*time.Second), time.Minute); ok {
+		t.Fatal("locked task is claimed")
+	}
+	if _, ok, _ := bc.ClaimTask(now.Add(2*time.Minute), time.Minute); !ok {
+		t.Fatal("abandoned task is not reclaimed")
+	}
+}
+
+func TestScheduleTaskDedup(t *testing.T) {
+	bc := newTestBotController(t)
+	bc.cfg.ReminderOffsets = "8h"
+	event := createTestEvent(t, bc, 1)
+
+	ensureEventReminders(bc, event)
+	ensureEventReminders(bc, event)
+	tasks, _ := bc.GetAllTasks()
+	if len(tasks) != 1 {
+		t.Fatalf("%d reminder tasks, want 1", len(tasks))
+	}
+
+	task, _, _ := bc.GetTask(tasks[0].ID)
+	bc.db.Model(&task).Update("status", TaskDone)
+	ensureEventReminders(bc, event)
+	task, _, _ = bc.GetTask(task.ID)
+	if task.Status != TaskDone {
+		t.Fatal("ensured task is reset")
+	}
+
+	date := event.Date.Add(time.Hour)
+	event.Date = &date
+	scheduleEventReminders(bc, event)
+	tasks, _ = bc.GetAllTasks()
+	if len(tasks) != 1 || tasks[0].Status != TaskPending || !tasks[0].RunAt.Equal(date.Add(-8*time.Hour)) {
+		t.Fatalf("reminder is not rescheduled: %+v", tasks)
+	}
+}
+
+func TestTaskWorkerStops(t *testing.T) {
+	bc := newTestBotController(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		taskWorker(ctx, bc)
+		close(stopped)
+	}()
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("worker doesn't stop after context is done")
+	}
+}
+
+func TestRecurringTaskNeverGivesUp(t *testing.T) {
+	bc := newTestBotController(t)
+	// sync task given up by older versions is revived on start
+	bc.CreateTask(Task{Type: SyncSheet, DedupKey: taskKey("syncsheet"), Status: TaskFailed, Attempts: 5})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var wg sync.WaitGroup
+	runScheduler(ctx, &wg, bc, 1)
+	wg.Wait()
+
+	now := time.Now()
+	for i := 0; i < 8; i++ {
+		task, ok, _ := bc.ClaimTask(now, time.Minute)
+		for ok && task.Type != SyncSheet {
+			task, ok, _ = bc.ClaimTask(now, time.Minute)
+		}
+		if !ok {
+			t.Fatalf("sync task is not scheduled after %d failures", i)
+		}
+		bc.FailTask(task, errors.New("sheets are down"), now)
+	}
+	var task Task
+	bc.db.Where("dedup_key = ?", "syncsheet").First(&task)
+	if task.Status != TaskPending {
+		t.Fatalf("sync task status is %d after failures, want pending", task.Status)
+	}
+}
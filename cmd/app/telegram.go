@@ -0,0 +1,26 @@
+package main
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TelegramClient is the part of Bot API used by bot, so it can be replaced in tests
+type TelegramClient interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	CopyMessage(config tgbotapi.CopyMessageConfig) (tgbotapi.MessageID, error)
+	GetChatMember(config tgbotapi.GetChatMemberConfig) (tgbotapi.ChatMember, error)
+	// MakeRequest calls methods which have no config in tgbotapi, e.g. refundStarPayment
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+	// Me is bot's own account
+	Me() tgbotapi.User
+}
+
+// botAPIClient is TelegramClient backed by tgbotapi
+type botAPIClient struct {
+	*tgbotapi.BotAPI
+}
+
+func (c botAPIClient) Me() tgbotapi.User {
+	return c.Self
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TemplateVars are values of placeholders like {first_name} in bot content
+type TemplateVars map[string]string
+
+// templateVariables are placeholders available in content, shown to admins in panel,
+// examples are substituted in preview of content
+var templateVariables = []struct {
+	Name        string
+	Description string
+	Example     string
+}{
+	{"first_name", "имя пользователя в Telegram", "Анна"},
+	{"name", "имя, указанное в брони", "Анна Иванова"},
+	{"event_title", "название мероприятия", "Вечер джаза"},
+	{"event_date", "дата мероприятия", "17.05.2030 (ПТ)"},
+	{"event_time", "время мероприятия", "19:30"},
+	{"event_venue", "место мероприятия", "Главный зал"},
+	{"seats_left", "свободных мест", "12"},
+	{"price", "стоимость мероприятия", "100 AED"},
+	{"amount", "сумма к оплате по брони", "100 AED"},
+}
+
+var placeholderRegexp = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// templateHelp lists placeholders for prompt of content editing
+func templateHelp() string {
+	lines := []string{"Placeholders:"}
+	for _, v := range templateVariables {
+		lines = append(lines, "{"+v.Name+"} - "+v.Description)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sampleTemplateVars returns examples of all variables, for preview in panel
+func sampleTemplateVars() TemplateVars {
+	vars := TemplateVars{}
+	for _, v := range templateVariables {
+		vars[v.Name] = v.Example
+	}
+	return vars
+}
+
+// userTemplateVars returns variables known for any user
+func userTemplateVars(bc BotController, userID int64) TemplateVars {
+	ui, _ := bc.GetUserInfo(userID)
+	return TemplateVars{"first_name": ui.FirstName}
+}
+
+// WithEvent adds variables of event
+func (v TemplateVars) WithEvent(bc BotController, locale string, event Event) TemplateVars {
+	date := event.LocalDate()
+	taken, _ := bc.CountTakenSeats(event.ID)
+	v["event_title"] = event.Title
+	v["event_date"] = date.Format("02.01.2006") + " (" + tr(locale, WeekLabels[int(date.Weekday())]) + ")"
+	v["event_time"] = date.Format("15:04")
+	v["event_venue"] = event.Venue
+	v["seats_left"] = strconv.FormatInt(event.SeatsLeft(taken), 10)
+	v["price"] = tr(locale, formatPrice(event.Price, event.Currency))
+	return v
+}
+
+// WithReservation adds variables of reservation and its event
+func (v TemplateVars) WithReservation(bc BotController, locale string, reservation Reservation, event Event) TemplateVars {
+	v.WithEvent(bc, locale, event)
+	v["name"] = reservation.EnteredName
+	v["amount"] = tr(locale, formatPrice(bc.ReservationPrice(reservation, event), event.Currency))
+	return v
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// renderTemplate substitutes known placeholders of text and moves entities accordingly.
+// Entity offsets are in UTF-16 code units as Telegram counts them.
+// Unknown placeholders are left as is, so text with braces isn't broken.
+func renderTemplate(text string, entities []tgbotapi.MessageEntity, vars TemplateVars) (string, []tgbotapi.MessageEntity) {
+	// substituted placeholders, start and end in source text, length of value
+	type expansion struct{ start, end, length int }
+	var expansions []expansion
+	var b strings.Builder
+	last, pos := 0, 0
+	for _, m := range placeholderRegexp.FindAllStringSubmatchIndex(text, -1) {
+		value, ok := vars[text[m[2]:m[3]]]
+		if !ok {
+			continue
+		}
+		start := pos + utf16Len(text[last:m[0]])
+		end := start + utf16Len(text[m[0]:m[1]])
+		b.WriteString(text[last:m[0]])
+		b.WriteString(value)
+		expansions = append(expansions, expansion{start, end, utf16Len(value)})
+		last, pos = m[1], end
+	}
+	if len(expansions) == 0 {
+		return text, entities
+	}
+	b.WriteString(text[last:])
+
+	// moves offset of source text to rendered one, offset inside placeholder
+	// is moved to start of value, or to its end for end of entity
+	move := func(offset int, isEnd bool) int {
+		delta := 0
+		for _, e := range expansions {
+			if offset >= e.end {
+				delta += e.length - (e.end - e.start)
+				continue
+			}
+			if offset > e.start {
+				if isEnd {
+					return e.start + delta + e.length
+				}
+				return e.start + delta
+			}
+			break
+		}
+		return offset + delta
+	}
+	rendered := []tgbotapi.MessageEntity{}
+	for _, entity := range entities {
+		start := move(entity.Offset, false)
+		end := move(entity.Offset+entity.Length, true)
+		if end <= start {
+			continue // placeholder inside entity became empty
+		}
+		entity.Offset, entity.Length = start, end-start
+		rendered = append(rendered, entity)
+	}
+	return b.String(), rendered
+}
+
+// RenderBotContent returns content of literal in locale with placeholders of its text substituted,
+// literal which isn't set is rendered from its default
+func (bc BotController) RenderBotContent(Locale string, Literal string, vars TemplateVars) RenderedContent {
+	c, err := bc.getBotContentLocale(Locale, Literal)
+	if err != nil {
+		c = BotContent{Type: ContentText, Content: literalDefault(Locale, Literal)}
+	}
+	return renderContent(c, vars)
+}
+
+// renderContent substitutes placeholders of text of content
+func renderContent(c BotContent, vars TemplateVars) RenderedContent {
+	var entities []tgbotapi.MessageEntity
+	json.Unmarshal([]byte(c.Metadata), &entities)
+	content := RenderedContent{Type: c.Type, Media: c.Media}
+	if content.Type == "" {
+		content.Type = ContentText
+	}
+	content.Text, content.Entities = renderTemplate(c.Content, entities, vars)
+	return content
+}
+
+// sendBotContent sends content of literal rendered for user
+func sendBotContent(bc BotController, chatID int64, locale string, literal string, vars TemplateVars) {
+	if err := sendContent(bc, chatID, bc.RenderBotContent(locale, literal, vars), nil); err != nil {
+		log.Printf("Error sending %s to %d: %s\n", literal, chatID, err)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	// "🎉" takes two UTF-16 units, bold covers "{first_name}", italic is after it
+	text := "🎉 Привет, {first_name}! Ждём {unknown} вас"
+	entities := []tgbotapi.MessageEntity{
+		{Type: "bold", Offset: 11, Length: 12},
+		{Type: "italic", Offset: 25, Length: 4},
+	}
+	got, gotEntities := renderTemplate(text, entities, TemplateVars{"first_name": "Анна 🌸"})
+
+	want := "🎉 Привет, Анна 🌸! Ждём {unknown} вас"
+	if got != want {
+		t.Fatalf("text is %q, want %q", got, want)
+	}
+	wantEntities := []tgbotapi.MessageEntity{
+		{Type: "bold", Offset: 11, Length: 7},
+		{Type: "italic", Offset: 20, Length: 4},
+	}
+	if len(gotEntities) != 2 || gotEntities[0] != wantEntities[0] || gotEntities[1] != wantEntities[1] {
+		t.Fatalf("entities are %+v, want %+v", gotEntities, wantEntities)
+	}
+}
+
+func TestRenderTemplateEntityInsidePlaceholder(t *testing.T) {
+	// bold starts inside first placeholder and ends right before second one,
+	// italic is entirely inside first placeholder
+	got, entities := renderTemplate("{name} и {name}", []tgbotapi.MessageEntity{
+		{Type: "bold", Offset: 2, Length: 7},
+		{Type: "italic", Offset: 1, Length: 2},
+	}, TemplateVars{"name": "Иван"})
+	if got != "Иван и Иван" {
+		t.Fatalf("text is %q", got)
+	}
+	if len(entities) != 2 || entities[0].Offset != 0 || entities[0].Length != 7 || entities[1].Offset != 0 || entities[1].Length != 4 {
+		t.Fatalf("entities are %+v", entities)
+	}
+
+	// placeholder with empty value drops entity which was only on it
+	_, entities = renderTemplate("Место: {event_venue}", []tgbotapi.MessageEntity{
+		{Type: "bold", Offset: 7, Length: 13},
+	}, TemplateVars{"event_venue": ""})
+	if len(entities) != 0 {
+		t.Fatalf("entities are %+v", entities)
+	}
+}
+
+func TestReservedMessageTemplate(t *testing.T) {
+	h := newTestHarness(t)
+	date := time.Date(2030, 5, 17, 19, 30, 0, 0, dubaiLocation)
+	event, _ := h.bc.CreateEvent(Event{Date: &date, Capacity: 5, Title: "Вечер джаза"})
+	entities, _ := json.Marshal([]tgbotapi.MessageEntity{{Type: "bold", Offset: 9, Length: 13}})
+	h.bc.SetBotContent("reserved_message", "Место на {event_title} в {event_time} ваше, свободно ещё {seats_left}", string(entities))
+
+	const userID = 42
+	h.press(userID, "reservedate:"+strconv.FormatInt(event.ID, 10))
+
+	msg := h.last(userID)
+	if want := "Место на Вечер джаза в 19:30 ваше, свободно ещё 4"; msg.Params["text"] != want {
+		t.Fatalf("text is %q, want %q", msg.Params["text"], want)
+	}
+	var got []tgbotapi.MessageEntity
+	json.Unmarshal([]byte(msg.Params["entities"]), &got)
+	if len(got) != 1 || got[0].Offset != 9 || got[0].Length != 11 {
+		t.Fatalf("entities are %s", msg.Params["entities"])
+	}
+}
@@ -1,30 +1,12 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-func Map[K comparable, T, V any](ts map[K]T, fn func(T) V) map[K]V {
-	result := make(map[K]V, len(ts))
-	for k, t := range ts {
-		result[k] = fn(t)
-	}
-	return result
-}
-
-func generateTgInlineKeyboard(buttonsCallback map[string]string) tgbotapi.InlineKeyboardMarkup {
-	rows := [][]tgbotapi.InlineKeyboardButton{}
-	for k, v := range buttonsCallback {
-		rows = append(rows,
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData(k, v),
-			),
-		)
-	}
-
-	return tgbotapi.NewInlineKeyboardMarkup(rows...)
-}
-
 func sendMessage(bc BotController, UserID int64, Msg string) {
 	msg := tgbotapi.NewMessage(UserID, Msg)
 	bc.bot.Send(msg)
@@ -35,3 +17,89 @@ func sendMessageKeyboard(bc BotController, UserID int64, Msg string, Kbd tgbotap
 	msg.ReplyMarkup = Kbd
 	bc.bot.Send(msg)
 }
+
+// Telegram limit of caption length, in UTF-16 code units
+const captionLimit = 1024
+
+// text of message carrying keyboard of album without caption, Telegram rejects empty messages
+const albumKeyboardText = "👇"
+
+// sendContent sends content with Telegram method of its type. Caption which doesn't fit
+// the limit is sent as separate message after media, so is text of album with keyboard,
+// as albums can't have one.
+func sendContent(bc BotController, chatID int64, content RenderedContent, markup interface{}) error {
+	if content.Type != ContentText && content.Media != "" {
+		caption := utf16Len(content.Text) <= captionLimit && !(content.Type == ContentAlbum && markup != nil)
+		if caption {
+			return sendMedia(bc, chatID, content, content.Text, content.Entities, markup)
+		}
+		if err := sendMedia(bc, chatID, content, "", nil, nil); err != nil {
+			return err
+		}
+		if content.Text == "" && markup == nil {
+			return nil
+		}
+		if content.Text == "" {
+			content.Text = albumKeyboardText
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, content.Text)
+	msg.Entities = content.Entities
+	if markup != nil {
+		msg.ReplyMarkup = markup
+	}
+	_, err := bc.bot.Send(msg)
+	return err
+}
+
+func sendMedia(bc BotController, chatID int64, content RenderedContent, caption string, entities []tgbotapi.MessageEntity, markup interface{}) error {
+	file := tgbotapi.FileID(content.Media)
+	var c tgbotapi.Chattable
+	switch content.Type {
+	case ContentPhoto:
+		m := tgbotapi.NewPhoto(chatID, file)
+		m.Caption, m.CaptionEntities, m.ReplyMarkup = caption, entities, markup
+		c = m
+	case ContentVideo:
+		m := tgbotapi.NewVideo(chatID, file)
+		m.Caption, m.CaptionEntities, m.ReplyMarkup = caption, entities, markup
+		c = m
+	case ContentAnimation:
+		m := tgbotapi.NewAnimation(chatID, file)
+		m.Caption, m.CaptionEntities, m.ReplyMarkup = caption, entities, markup
+		c = m
+	case ContentDocument:
+		m := tgbotapi.NewDocument(chatID, file)
+		m.Caption, m.CaptionEntities, m.ReplyMarkup = caption, entities, markup
+		c = m
+	case ContentAlbum:
+		var album AlbumMedia
+		if err := json.Unmarshal([]byte(content.Media), &album); err != nil {
+			return err
+		}
+		files := []interface{}{}
+		for i, item := range album.Items {
+			base := tgbotapi.BaseInputMedia{Type: item.Type, Media: tgbotapi.FileID(item.FileID)}
+			if i == 0 {
+				// caption of first file is shown as caption of album
+				base.Caption, base.CaptionEntities = caption, entities
+			}
+			switch item.Type {
+			case ContentVideo:
+				files = append(files, tgbotapi.InputMediaVideo{BaseInputMedia: base})
+			case ContentDocument:
+				files = append(files, tgbotapi.InputMediaDocument{BaseInputMedia: base})
+			default:
+				files = append(files, tgbotapi.InputMediaPhoto{BaseInputMedia: base})
+			}
+		}
+		// result is array of messages, which Send can't decode
+		_, err := bc.bot.Request(tgbotapi.NewMediaGroup(chatID, files))
+		return err
+	default:
+		return fmt.Errorf("unknown content type %s", content.Type)
+	}
+	_, err := bc.bot.Send(c)
+	return err
+}
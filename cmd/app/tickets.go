@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var ticketStatusString = []string{
+	"Открыт",
+	"Закрыт",
+}
+
+func supportChatID(bc BotController) (int64, error) {
+	chatidstr, err := bc.GetBotContentVerbose("supportchatid")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(chatidstr, 10, 64)
+}
+
+// ticketMedia returns type and file id of message attachment, empty for text messages
+func ticketMedia(msg *tgbotapi.Message) (string, string) {
+	switch {
+	case len(msg.Photo) > 0:
+		return "photo", largestPhoto(msg.Photo)
+	case msg.Voice != nil:
+		return "voice", msg.Voice.FileID
+	case msg.VideoNote != nil:
+		return "video_note", msg.VideoNote.FileID
+	case msg.Video != nil:
+		return "video", msg.Video.FileID
+	case msg.Audio != nil:
+		return "audio", msg.Audio.FileID
+	case msg.Document != nil:
+		return "document", msg.Document.FileID
+	case msg.Sticker != nil:
+		return "sticker", msg.Sticker.FileID
+	}
+	return "", ""
+}
+
+func newTicketMessage(ticket Ticket, msg *tgbotapi.Message, fromSupport bool, supportMessageID int) TicketMessage {
+	mediaType, fileID := ticketMedia(msg)
+	text := msg.Text
+	if text == "" {
+		text = msg.Caption
+	}
+	return TicketMessage{
+		TicketID:         ticket.ID,
+		FromSupport:      fromSupport,
+		AuthorID:         msg.From.ID,
+		Text:             text,
+		MediaType:        mediaType,
+		FileID:           fileID,
+		SupportMessageID: supportMessageID,
+	}
+}
+
+func ticketKeyboard(locale string, ticket Ticket) tgbotapi.InlineKeyboardMarkup {
+	id := strconv.FormatInt(ticket.ID, 10)
+	if ticket.Status == TicketClosed {
+		return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(tr(locale, "Открыть снова"), "ticketreopen:"+id),
+		))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(tr(locale, "Закрыть"), "ticketclose:"+id),
+	))
+}
+
+func ticketHeader(bc BotController, ticket Ticket) string {
+	ui, _ := bc.GetUserInfo(ticket.UserID)
+	return fmt.Sprintf("Тикет #%d (%s)\nUser: %s %s\nUsername: %s\nID: %d\n\nОтветьте реплаем на сообщение тикета, чтобы ответить пользователю",
+		ticket.ID, ticketStatusString[ticket.Status], ui.FirstName, ui.LastName, ui.Username, ticket.UserID)
+}
+
+// isTicketFollowUp tells if user's message should be added to their open ticket
+func isTicketFollowUp(bc BotController, update tgbotapi.Update, user User) bool {
+	if !update.Message.Chat.IsPrivate() || (user.State != StartState && user.State != "") {
+		return false
+	}
+	_, err := bc.GetOpenTicket(user.ID)
+	return err == nil
+}
+
+// handleTicketMessage adds user's message to open ticket or opens new one, and copies it to support chat
+func handleTicketMessage(bc BotController, update tgbotapi.Update, user User) {
+	chatid, err := supportChatID(bc)
+	if err != nil {
+		var admins []User
+		bc.db.Where("role_bitmask & 1 = ?", 1).Find(&admins)
+		for _, admin := range admins {
+			bc.bot.Send(tgbotapi.NewMessage(admin.ID, "Support ChatID is not set!!!"))
+		}
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+
+	ticket, err := bc.GetOpenTicket(user.ID)
+	isNew := err != nil
+	if isNew {
+		ticket, err = bc.CreateTicket(Ticket{UserID: user.ID, Status: TicketOpen})
+		if err != nil {
+			log.Printf("Error creating ticket: %s\n", err)
+			sendMessage(bc, user.ID, "Something went wrong, try again...")
+			return
+		}
+		header := tgbotapi.NewMessage(chatid, ticketHeader(bc, ticket))
+		header.ReplyMarkup = ticketKeyboard(defaultLocale, ticket)
+		sent, err := bc.bot.Send(header)
+		if err != nil {
+			log.Printf("Error sending ticket %d to support chat: %s\n", ticket.ID, err)
+			sendMessage(bc, user.ID, "Something went wrong, try again...")
+			return
+		}
+		ticket.SupportMessageID = sent.MessageID
+		bc.UpdateTicket(ticket)
+	}
+
+	copyConfig := tgbotapi.NewCopyMessage(chatid, update.Message.Chat.ID, update.Message.MessageID)
+	copyConfig.ReplyToMessageID = ticket.SupportMessageID
+	copyConfig.AllowSendingWithoutReply = true
+	copied, err := bc.bot.CopyMessage(copyConfig)
+	if err != nil {
+		log.Printf("Error copying message to ticket %d: %s\n", ticket.ID, err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+	if _, err := bc.AddTicketMessage(newTicketMessage(ticket, update.Message, false, copied.MessageID)); err != nil {
+		log.Printf("Error saving message of ticket %d: %s\n", ticket.ID, err)
+	}
+
+	// follow-ups are added silently
+	if isNew || user.State == "leaveticket" {
+		resetState(bc, user)
+		sendBotContent(bc, user.ID, user.Locale, "sended_notify", userTemplateVars(bc, user.ID))
+	}
+}
+
+// isSupportReply tells if message is staff's reply to bot's message in support chat
+func isSupportReply(bc BotController, msg *tgbotapi.Message) bool {
+	if msg.ReplyToMessage == nil || msg.ReplyToMessage.From == nil || msg.ReplyToMessage.From.ID != bc.bot.Me().ID {
+		return false
+	}
+	chatid, err := supportChatID(bc)
+	return err == nil && msg.Chat.ID == chatid
+}
+
+// handleSupportReply delivers staff's reply in support chat to author of ticket
+func handleSupportReply(bc BotController, update tgbotapi.Update) {
+	msg := update.Message
+	ticket, err := bc.GetTicketBySupportMessage(msg.ReplyToMessage.MessageID)
+	if err != nil {
+		// reply to bot's message which isn't part of ticket, e.g. payment notification
+		return
+	}
+	if ticket.Status == TicketClosed {
+		reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Тикет #%d закрыт, откройте его снова, чтобы ответить", ticket.ID))
+		reply.ReplyToMessageID = msg.MessageID
+		bc.bot.Send(reply)
+		return
+	}
+
+	if _, err := bc.bot.CopyMessage(tgbotapi.NewCopyMessage(ticket.UserID, msg.Chat.ID, msg.MessageID)); err != nil {
+		log.Printf("Error delivering reply to ticket %d: %s\n", ticket.ID, err)
+		reply := tgbotapi.NewMessage(msg.Chat.ID, "Не удалось доставить ответ: "+err.Error())
+		reply.ReplyToMessageID = msg.MessageID
+		bc.bot.Send(reply)
+		return
+	}
+	if _, err := bc.AddTicketMessage(newTicketMessage(ticket, msg, true, msg.MessageID)); err != nil {
+		log.Printf("Error saving message of ticket %d: %s\n", ticket.ID, err)
+	}
+}
+
+// handleTicketCallback closes or reopens ticket, by staff in support chat or by author of ticket
+func handleTicketCallback(bc BotController, update tgbotapi.Update, user User) {
+	tokens := strings.Split(update.CallbackQuery.Data, ":")
+	if len(tokens) < 2 {
+		return
+	}
+	ticketID, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing ticket id: %s\n", err)
+		return
+	}
+	ticket, err := bc.GetTicket(ticketID)
+	if err != nil {
+		return
+	}
+	chatid, _ := supportChatID(bc)
+	fromSupport := update.CallbackQuery.Message != nil && update.CallbackQuery.Message.Chat.ID == chatid
+	if !fromSupport && ticket.UserID != user.ID {
+		return
+	}
+
+	status := TicketClosed
+	if tokens[0] == "ticketreopen" {
+		status = TicketOpen
+		// user may have opened new ticket since this one was closed
+		if open, err := bc.GetOpenTicket(ticket.UserID); err == nil && open.ID != ticket.ID {
+			if !fromSupport {
+				sendMessage(bc, user.ID, tr(user.Locale, "У вас уже есть открытое обращение, просто напишите сообщение"))
+			}
+			return
+		}
+	}
+	changed, err := bc.ChangeTicketStatus(ticket.ID, status)
+	if err != nil {
+		log.Printf("Error changing status of ticket %d: %s\n", ticket.ID, err)
+		return
+	}
+	if !changed {
+		return
+	}
+	ticket.Status = status
+
+	// keep buttons of header in support chat actual
+	header := tgbotapi.NewEditMessageTextAndMarkup(chatid, ticket.SupportMessageID, ticketHeader(bc, ticket), ticketKeyboard(defaultLocale, ticket))
+	if _, err := bc.bot.Send(header); err != nil {
+		log.Printf("Error updating header of ticket %d: %s\n", ticket.ID, err)
+	}
+
+	who := "пользователем"
+	if fromSupport {
+		who = update.CallbackQuery.From.UserName
+	}
+	if status == TicketClosed {
+		notifySupportChat(bc, fmt.Sprintf("Тикет #%d закрыт (%s)", ticket.ID, who))
+		locale := bc.UserLocale(ticket.UserID)
+		sendContent(bc, ticket.UserID, bc.RenderBotContent(locale, "ticket_closed_message", userTemplateVars(bc, ticket.UserID)), ticketKeyboard(locale, ticket))
+	} else {
+		notifySupportChat(bc, fmt.Sprintf("Тикет #%d открыт снова (%s)", ticket.ID, who))
+		sendBotContent(bc, ticket.UserID, bc.UserLocale(ticket.UserID), "ticket_reopened_message", userTemplateVars(bc, ticket.UserID))
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const testSupportChatID = -100
+
+func TestTicketThread(t *testing.T) {
+	bc := newTestBotController(t)
+	bot, fake := newFakeBotAPI(t)
+	bc.bot = bot
+	bc.SetBotContent("supportchatid", strconv.Itoa(testSupportChatID), "")
+
+	const userID = 42
+	user := bc.GetUser(userID)
+	bc.db.Model(&user).Update("state", "leaveticket")
+	user.State = "leaveticket"
+	handleDefaultMessage(bc, userMessage(userID, 10, "help"), user)
+
+	ticket, err := bc.GetOpenTicket(userID)
+	if err != nil {
+		t.Fatalf("ticket is not opened: %s", err)
+	}
+	copies := fake.find("copyMessage")
+	if len(copies) != 1 || copies[0].Params["chat_id"] != strconv.Itoa(testSupportChatID) {
+		t.Fatalf("message is not copied to support chat: %+v", copies)
+	}
+	if copies[0].Params["reply_to_message_id"] != strconv.Itoa(ticket.SupportMessageID) {
+		t.Errorf("message is not attached to ticket header")
+	}
+
+	// follow-up goes to the same ticket
+	user = bc.GetUser(userID)
+	handleDefaultMessage(bc, userMessage(userID, 11, "more details"), user)
+	messages, _ := bc.GetTicketMessages(ticket.ID)
+	if len(messages) != 2 {
+		t.Fatalf("%d messages in ticket, want 2", len(messages))
+	}
+
+	// staff replies to user's message in support chat
+	reply := tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 500,
+		From:      &tgbotapi.User{ID: 7},
+		Chat:      &tgbotapi.Chat{ID: testSupportChatID, Type: "supergroup"},
+		Text:      "answer",
+		ReplyToMessage: &tgbotapi.Message{
+			MessageID: messages[1].SupportMessageID,
+			From:      &tgbotapi.User{ID: bot.Me().ID},
+		},
+	}}
+	ProcessUpdate(bc, reply)
+	copies = fake.find("copyMessage")
+	last := copies[len(copies)-1]
+	if last.Params["chat_id"] != strconv.Itoa(userID) || last.Params["message_id"] != "500" {
+		t.Fatalf("reply is not delivered to user: %+v", last)
+	}
+
+	// closed ticket doesn't take follow-ups
+	bc.ChangeTicketStatus(ticket.ID, TicketClosed)
+	if isTicketFollowUp(bc, userMessage(userID, 12, "thanks"), user) {
+		t.Error("message is added to closed ticket")
+	}
+}
+
+func TestSubscriptionGate(t *testing.T) {
+	bc := newTestBotController(t)
+	bot, fake := newFakeBotAPI(t)
+	bc.bot = bot
+	bc.SetBotContent("channelid", "-200", "")
+
+	cases := []struct {
+		member     tgbotapi.ChatMember
+		subscribed bool
+	}{
+		{tgbotapi.ChatMember{Status: "creator"}, true},
+		{tgbotapi.ChatMember{Status: "administrator"}, true},
+		{tgbotapi.ChatMember{Status: "member"}, true},
+		{tgbotapi.ChatMember{Status: "restricted", IsMember: true}, true},
+		{tgbotapi.ChatMember{Status: "restricted", IsMember: false}, false},
+		{tgbotapi.ChatMember{Status: "left"}, false},
+		{tgbotapi.ChatMember{Status: "kicked"}, false},
+	}
+	for _, c := range cases {
+		c.member.User = &tgbotapi.User{ID: 42}
+		fake.setResult("getChatMember", c.member)
+		if got := isSubscribed(bc, 42); got != c.subscribed {
+			t.Errorf("status %s (member %v): subscribed %v, want %v", c.member.Status, c.member.IsMember, got, c.subscribed)
+		}
+	}
+}
+
+func TestLeaveTicketButtonTwice(t *testing.T) {
+	h := newTestHarness(t)
+	h.bc.SetBotContent("channelid", "-200", "")
+	h.fake.setResult("getChatMember", tgbotapi.ChatMember{Status: "member", User: &tgbotapi.User{ID: 42}})
+
+	const userID = 42
+	for i := 1; i <= 2; i++ {
+		h.press(userID, "leaveticket")
+		prompts := 0
+		for _, r := range h.fake.sentTo(userID) {
+			if r.Params["text"] == "Напишите ваш вопрос одним сообщением." {
+				prompts++
+			}
+		}
+		if prompts != i {
+			t.Fatalf("%d prompts after %d presses", prompts, i)
+		}
+	}
+}
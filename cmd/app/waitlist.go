@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var waitlistStatusString = []string{
+	"Ожидает",
+	"Предложено место",
+	"Принято",
+	"Отказ",
+	"Предложение истекло",
+}
+
+func waitlistJoinKeyboard(locale string, eventID int64) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(tr(locale, "Встать в лист ожидания"), "waitjoin:"+strconv.FormatInt(eventID, 10)),
+	))
+}
+
+func handleWaitlistJoin(bc BotController, user User, eventID int64) {
+	event, err := bc.GetEvent(eventID)
+	if err != nil || event.Hidden {
+		log.Printf("Error getting event %d: %s\n", eventID, err)
+		return
+	}
+	var booked int64
+	bc.db.Model(&Reservation{}).Where("user_id = ? AND event_id = ? AND status IN ?", user.ID, eventID, seatHoldingStatuses).Count(&booked)
+	if booked > 0 {
+		sendMessage(bc, user.ID, tr(user.Locale, "Вы уже забронировали место на это мероприятие"))
+		return
+	}
+
+	entry, err := bc.JoinWaitlist(user.ID, eventID)
+	if err != nil {
+		log.Printf("Error joining waitlist: %s\n", err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+	if entry.Status == Waiting {
+		content := bc.RenderBotContent(user.Locale, "waitlist_joined_message",
+			userTemplateVars(bc, user.ID).WithEvent(bc, user.Locale, event))
+		content.Text = fmt.Sprintf(tr(user.Locale, "%s\nВаше место в очереди: %d"), content.Text, bc.WaitlistPosition(entry))
+		sendContent(bc, user.ID, content, nil)
+	}
+
+	// seat may be already free, e.g. someone cancelled right before
+	promoteWaitlist(bc, eventID)
+}
+
+// promoteWaitlist offers every free seat of event to next users in its waitlist.
+// Should be called whenever a seat is released.
+func promoteWaitlist(bc BotController, eventID int64) {
+	event, err := bc.GetEvent(eventID)
+	if err != nil || event.Hidden || event.Date.Before(time.Now()) {
+		return
+	}
+	for {
+		expiresAt := time.Now().Add(bc.cfg.WaitlistOfferTimeout)
+		entry, offered, err := bc.OfferNextInWaitlist(eventID, expiresAt)
+		if err != nil {
+			log.Printf("Error promoting waitlist of event %d: %s\n", eventID, err)
+			return
+		}
+		if !offered {
+			return
+		}
+		sendWaitlistOffer(bc, entry, event)
+	}
+}
+
+func sendWaitlistOffer(bc BotController, entry WaitlistEntry, event Event) {
+	id := strconv.FormatInt(entry.ID, 10)
+	locale := bc.UserLocale(entry.UserID)
+	content := bc.RenderBotContent(locale, "waitlist_offer_message",
+		userTemplateVars(bc, entry.UserID).WithEvent(bc, locale, event))
+	content.Text = fmt.Sprintf(tr(locale, "%s\n\n%s\n\nПредложение действует до %s"),
+		content.Text,
+		eventDetails(locale, event),
+		entry.OfferExpiresAt.In(dubaiLocation).Format("02.01 15:04"),
+	)
+	sendContent(bc, entry.UserID, content, tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(tr(locale, "Принять"), "waitaccept:"+id),
+		tgbotapi.NewInlineKeyboardButtonData(tr(locale, "Отказаться"), "waitdecline:"+id),
+	)))
+}
+
+func handleWaitlistOfferAnswer(bc BotController, user User, entryID int64, accept bool) {
+	entry, err := bc.GetWaitlistEntry(entryID)
+	if err != nil || entry.UserID != user.ID {
+		return
+	}
+	if entry.Status != Offered || entry.OfferExpiresAt.Before(time.Now()) {
+		sendBotContent(bc, user.ID, user.Locale, "waitlist_offer_expired_message", userTemplateVars(bc, user.ID))
+		return
+	}
+
+	if !accept {
+		if ok, _ := bc.SetWaitlistEntryStatus(entry.ID, Offered, Declined); !ok {
+			sendBotContent(bc, user.ID, user.Locale, "waitlist_offer_expired_message", userTemplateVars(bc, user.ID))
+			return
+		}
+		sendMessage(bc, user.ID, tr(user.Locale, "Вы отказались от места"))
+		promoteWaitlist(bc, entry.EventID)
+		return
+	}
+
+	event, err := bc.GetEvent(entry.EventID)
+	if err != nil {
+		log.Printf("Error getting event %d: %s\n", entry.EventID, err)
+		return
+	}
+	reservation, err := bc.AcceptWaitlistOffer(entry, "Не указано")
+	if errors.Is(err, ErrOfferExpired) || errors.Is(err, ErrEventHidden) {
+		sendBotContent(bc, user.ID, user.Locale, "waitlist_offer_expired_message", userTemplateVars(bc, user.ID))
+		return
+	}
+	if err != nil {
+		log.Printf("Error booking offered seat: %s\n", err)
+		sendMessage(bc, user.ID, "Something went wrong, try again...")
+		return
+	}
+	startReservationName(bc, user, event, reservation)
+}
+
+// expireWaitlistOffers releases seats offered to users who didn't answer in time
+func expireWaitlistOffers(ctx context.Context, bc BotController) {
+	for {
+		entries, _ := bc.GetExpiredWaitlistOffers(time.Now())
+		for _, entry := range entries {
+			// offer may be answered right at its deadline
+			if ok, _ := bc.SetWaitlistEntryStatus(entry.ID, Offered, OfferExpired); !ok {
+				continue
+			}
+			sendBotContent(bc, entry.UserID, bc.UserLocale(entry.UserID), "waitlist_offer_expired_message", userTemplateVars(bc, entry.UserID))
+			promoteWaitlist(bc, entry.EventID)
+		}
+
+		if !sleepContext(ctx, 60*time.Second) {
+			return
+		}
+	}
+}
+
+func handleWaitlistPanel(bc BotController, user User, eventID int64) {
+	entries, _ := bc.GetWaitlist(eventID)
+	rows := [][]tgbotapi.InlineKeyboardButton{}
+	lines := []string{fmt.Sprintf("Лист ожидания мероприятия #%d", eventID)}
+	if len(entries) == 0 {
+		lines = append(lines, "Пусто")
+	}
+	for i, entry := range entries {
+		ui, _ := bc.GetUserInfo(entry.UserID)
+		label := fmt.Sprintf("%d. %s %s (@%s)", i+1, ui.FirstName, ui.LastName, ui.Username)
+		lines = append(lines, label+" - "+waitlistStatusString[entry.Status])
+		if entry.Status != Waiting {
+			continue
+		}
+		id := strconv.FormatInt(entry.ID, 10)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(i+1)+" ↑", "waitlistup:"+id),
+			tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(i+1)+" ↓", "waitlistdown:"+id),
+			tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(i+1)+" ✕", "waitlistremove:"+id),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Назад", "eventview:"+strconv.FormatInt(eventID, 10)),
+	))
+	sendMessageKeyboard(bc, user.ID, strings.Join(lines, "\n"), tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func handleWaitlistAdminCallback(bc BotController, update tgbotapi.Update, user User) {
+	tokens := strings.Split(update.CallbackQuery.Data, ":")
+	if len(tokens) < 2 {
+		return
+	}
+	entryID, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		log.Printf("Error parsing waitlist entry id: %s\n", err)
+		return
+	}
+	entry, err := bc.GetWaitlistEntry(entryID)
+	if err != nil {
+		return
+	}
+
+	switch tokens[0] {
+	case "waitlistup":
+		err = bc.MoveWaitlistEntry(entryID, true)
+	case "waitlistdown":
+		err = bc.MoveWaitlistEntry(entryID, false)
+	case "waitlistremove":
+		_, err = bc.SetWaitlistEntryStatus(entryID, Waiting, Declined)
+	}
+	if err != nil {
+		log.Printf("Error updating waitlist: %s\n", err)
+	}
+	handleWaitlistPanel(bc, user, entry.EventID)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/akulij/ticketbot/config"
+)
+
+const (
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// updates are a few kilobytes, bigger bodies aren't from Telegram
+	webhookMaxBody = 1 << 20
+)
+
+// webhookUpdates is channel of updates which is closed only after no handler sends to it
+type webhookUpdates struct {
+	ch   chan tgbotapi.Update
+	done chan struct{}
+	mu   sync.RWMutex // held for reading while handler sends
+}
+
+func newWebhookUpdates(size int) *webhookUpdates {
+	return &webhookUpdates{ch: make(chan tgbotapi.Update, size), done: make(chan struct{})}
+}
+
+// send passes update to channel, returns false if request is cancelled or channel is closing
+func (u *webhookUpdates) send(ctx context.Context, update tgbotapi.Update) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	select {
+	case <-u.done:
+		return false
+	default:
+	}
+	select {
+	case u.ch <- update:
+		return true
+	case <-u.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// close unblocks handlers waiting to send and closes channel after they return
+func (u *webhookUpdates) close() {
+	close(u.done)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	close(u.ch)
+}
+
+// webhookHandler passes updates posted by Telegram to updates, requests without valid secret token are rejected
+func webhookHandler(secret string, updates *webhookUpdates) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(webhookSecretHeader)), []byte(secret)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var update tgbotapi.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, webhookMaxBody)).Decode(&update); err != nil {
+			log.Printf("Error decoding webhook update: %s\n", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !updates.send(r.Context(), update) {
+			// Telegram retries update later
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// randomWebhookSecret is used when WEBHOOKSECRET isn't set, so updates can't be forged
+func randomWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// setWebhook registers webhook in Telegram, tgbotapi doesn't support secret token so request is made by hand
+func setWebhook(bot TelegramClient, cfg config.Config) error {
+	params := tgbotapi.Params{"url": cfg.WebhookURL}
+	params.AddNonEmpty("secret_token", cfg.WebhookSecret)
+	_, err := bot.MakeRequest("setWebhook", params)
+	return err
+}
+
+func deleteWebhook(bot TelegramClient) error {
+	_, err := bot.Request(tgbotapi.DeleteWebhookConfig{})
+	return err
+}
+
+// startWebhook registers webhook and serves it on cfg.WebhookListen. Returned stop function
+// removes webhook, stops server and closes updates channel.
+// Random secret token is registered if cfg.WebhookSecret is empty.
+func startWebhook(bot TelegramClient, cfg config.Config) (tgbotapi.UpdatesChannel, func(), error) {
+	link, err := url.Parse(cfg.WebhookURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.WebhookSecret == "" {
+		if cfg.WebhookSecret, err = randomWebhookSecret(); err != nil {
+			return nil, nil, err
+		}
+		log.Printf("WEBHOOKSECRET isn't set, using random secret token\n")
+	}
+	path := link.Path
+	if path == "" {
+		path = "/"
+	}
+
+	updates := newWebhookUpdates(100)
+	mux := http.NewServeMux()
+	mux.Handle(path, webhookHandler(cfg.WebhookSecret, updates))
+	srv := &http.Server{Addr: cfg.WebhookListen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Panic(err)
+		}
+	}()
+
+	if err := setWebhook(bot, cfg); err != nil {
+		srv.Close()
+		return nil, nil, err
+	}
+	log.Printf("Listening for webhook %s on %s\n", cfg.WebhookURL, cfg.WebhookListen)
+
+	stop := func() {
+		if err := deleteWebhook(bot); err != nil {
+			log.Printf("Error deleting webhook: %s\n", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		// in-flight requests get a chance to pass their updates before channel is closed
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Error stopping webhook server: %s\n", err)
+		}
+		updates.close()
+	}
+	return updates.ch, stop, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/akulij/ticketbot/config"
+)
+
+func TestWebhookSecret(t *testing.T) {
+	updates := newWebhookUpdates(1)
+	ch := updates.ch
+	handler := webhookHandler("s3cret", updates)
+	post := func(secret string) int {
+		r := httptest.NewRequest(http.MethodPost, "/tg", strings.NewReader(`{"update_id": 7, "message": {"text": "hi"}}`))
+		if secret != "" {
+			r.Header.Set(webhookSecretHeader, secret)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := post(""); code != http.StatusUnauthorized {
+		t.Fatalf("request without secret: %d", code)
+	}
+	if code := post("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("request with wrong secret: %d", code)
+	}
+	if len(ch) != 0 {
+		t.Fatal("update of unauthorized request is passed")
+	}
+	if code := post("s3cret"); code != http.StatusOK {
+		t.Fatalf("request with secret: %d", code)
+	}
+	if update := <-ch; update.UpdateID != 7 || update.Message.Text != "hi" {
+		t.Fatalf("got update %+v", update)
+	}
+}
+
+func TestWebhookStartStop(t *testing.T) {
+	bot, fake := newFakeBotAPI(t)
+	cfg := config.Config{WebhookURL: "https://example.com/tg", WebhookListen: "127.0.0.1:0", WebhookSecret: "s3cret"}
+
+	updates, stop, err := startWebhook(bot, cfg)
+	if err != nil {
+		t.Fatalf("start webhook: %s", err)
+	}
+	set := fake.find("setWebhook")
+	if len(set) != 1 || set[0].Params["url"] != cfg.WebhookURL || set[0].Params["secret_token"] != "s3cret" {
+		t.Fatalf("setWebhook requests: %+v", set)
+	}
+
+	stop()
+	if len(fake.find("deleteWebhook")) != 1 {
+		t.Fatal("webhook is not deleted on stop")
+	}
+	if _, ok := <-updates; ok {
+		t.Fatal("updates channel is not closed")
+	}
+}
+
+func TestWebhookRandomSecret(t *testing.T) {
+	bot, fake := newFakeBotAPI(t)
+	cfg := config.Config{WebhookURL: "https://example.com/tg", WebhookListen: "127.0.0.1:0"}
+
+	_, stop, err := startWebhook(bot, cfg)
+	if err != nil {
+		t.Fatalf("start webhook: %s", err)
+	}
+	defer stop()
+	if set := fake.find("setWebhook"); len(set) != 1 || len(set[0].Params["secret_token"]) < 32 {
+		t.Fatalf("setWebhook requests: %+v", set)
+	}
+}
+
+func TestWebhookBodyLimit(t *testing.T) {
+	updates := newWebhookUpdates(1)
+	body := `{"update_id": 7, "message": {"text": "` + strings.Repeat("a", webhookMaxBody) + `"}}`
+	r := httptest.NewRequest(http.MethodPost, "/tg", strings.NewReader(body))
+	r.Header.Set(webhookSecretHeader, "s3cret")
+	w := httptest.NewRecorder()
+	webhookHandler("s3cret", updates).ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest || len(updates.ch) != 0 {
+		t.Fatalf("oversized request: %d, %d updates", w.Code, len(updates.ch))
+	}
+}
+
+func TestWebhookCloseWithBlockedHandler(t *testing.T) {
+	updates := newWebhookUpdates(1)
+	handler := webhookHandler("s3cret", updates)
+	post := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/tg", strings.NewReader(`{"update_id": 7}`))
+		r.Header.Set(webhookSecretHeader, "s3cret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := post(); code != http.StatusOK {
+		t.Fatalf("first request: %d", code)
+	}
+
+	// channel is full and nobody reads it, so handler waits
+	var wg sync.WaitGroup
+	var code int
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		code = post()
+	}()
+	updates.close()
+	wg.Wait()
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("blocked request: %d", code)
+	}
+	if code := post(); code != http.StatusServiceUnavailable {
+		t.Fatalf("request after close: %d", code)
+	}
+	if _, ok := <-updates.ch; !ok {
+		t.Fatal("queued update is lost")
+	}
+	if _, ok := <-updates.ch; ok {
+		t.Fatal("updates channel is not closed")
+	}
+}
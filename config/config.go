@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 	"log"
+	"time"
 
 	"github.com/sethvargo/go-envconfig"
 )
@@ -12,6 +13,24 @@ type Config struct {
 	AdminPass string `env:"ADMINPASSWORD, required"` // to activate admin privileges in bot type command: /secret `AdminPass`
 	AdminID   *int64 `env:"ADMINID"`                 // optional admin ID for notifications
 	SheetID   string `env:"SHEETID, required"`       // id of google sheet where users will be synced
+
+	APIEndpoint          string `env:"APIENDPOINT"`          // optional Bot API endpoint, e.g. fake server for local testing
+	PaymentProviderToken string `env:"PAYMENTPROVIDERTOKEN"` // token from @BotFather, leave empty for payments in Telegram Stars
+
+	WebhookURL    string `env:"WEBHOOKURL"`                   // public URL of webhook, e.g. https://example.com/tg/webhook, leave empty for long polling
+	WebhookListen string `env:"WEBHOOKLISTEN, default=:8080"` // address of webhook server behind reverse proxy
+	WebhookSecret string `env:"WEBHOOKSECRET"`                // secret token Telegram sends in X-Telegram-Bot-Api-Secret-Token header, random one is used if empty
+
+	WaitlistOfferTimeout time.Duration `env:"WAITLISTOFFERTIMEOUT, default=30m"`  // how long promoted user from waitlist may accept the seat
+	CancellationCutoff   time.Duration `env:"CANCELLATIONCUTOFF, default=24h"`    // users can't cancel reservation later than this before event
+	ReservationHold      time.Duration `env:"RESERVATIONHOLD, default=2h"`        // unpaid reservation is released after this time, 0 to hold forever
+	ReservationHoldWarn  time.Duration `env:"RESERVATIONHOLDWARN, default=15m"`   // warn user this long before unpaid reservation is released
+	RefundCutoff         time.Duration `env:"REFUNDCUTOFF, default=48h"`          // users can request refund not later than this before event
+	ReminderOffsets      string        `env:"REMINDEROFFSETS, default=24h,8h,1h"` // when reminders are sent before event, may be overridden per event
+
+	TaskWorkers     int `env:"TASKWORKERS, default=4"`       // number of background task workers
+	UpdateWorkers   int `env:"UPDATEWORKERS, default=16"`    // number of users whose updates are processed in parallel
+	UpdateQueueSize int `env:"UPDATEQUEUESIZE, default=100"` // updates waiting for each worker, receiving stops while queue is full
 }
 
 func GetConfig() Config {